@@ -0,0 +1,48 @@
+package marathoner
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+// nonceSize is a size of random challenge in bytes
+const nonceSize = 32
+
+// Roles mix into proofs, so proof of one side
+// cannot be replayed by the other side
+const (
+	proofListener = "listener"
+	proofUpdater  = "updater"
+)
+
+// newNonce returns random challenge for the other side
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+// tokenProof returns proof of knowing shared token for specified
+// role and challenge, token itself is never sent over the wire
+func tokenProof(token, role string, nonce []byte) []byte {
+	m := hmac.New(sha256.New, []byte(token))
+	m.Write([]byte(role))
+	m.Write(nonce)
+	return m.Sum(nil)
+}
+
+// proofValid checks proof of knowing shared token for specified
+// role and challenge, challenges of unexpected size are rejected
+func proofValid(token, role string, nonce, proof []byte) bool {
+	if len(nonce) != nonceSize {
+		return false
+	}
+
+	return hmac.Equal(proof, tokenProof(token, role, nonce))
+}
@@ -0,0 +1,86 @@
+package marathoner
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+// authTestClient returns client connected to configurator
+// that counts applied states and authenticates with token
+func authTestClient(token string) (*client, *countingConfigurator) {
+	impl := &countingConfigurator{}
+	l := NewListener(nil, impl)
+
+	rs := rpc.NewServer()
+	rs.Register(newConfigurator(l.apply, l.hello(), token))
+
+	sc, cc := net.Pipe()
+	go rs.ServeConn(sc)
+
+	return newClient(cc), impl
+}
+
+func TestHelloAuthenticates(t *testing.T) {
+	c, impl := authTestClient("secret")
+	defer c.Close()
+
+	if err := c.hello("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.reload(StateUpdate{State: State{}, Generation: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if impl.applied != 1 {
+		t.Fatalf("expected 1 applied state, got %d", impl.applied)
+	}
+}
+
+func TestHelloRejectsListenerWithoutToken(t *testing.T) {
+	c, impl := authTestClient("")
+
+	u := NewUpdater()
+	u.SetToken("secret")
+	u.update(State{"/app": App{Name: "/app"}})
+
+	if err := u.handleConnection(c); err == nil {
+		t.Fatal("listener without token is accepted")
+	}
+
+	if impl.applied != 0 {
+		t.Fatalf("listener without token received %d states", impl.applied)
+	}
+}
+
+func TestHelloRejectsListenerWithWrongToken(t *testing.T) {
+	c, _ := authTestClient("wrong")
+	defer c.Close()
+
+	if err := c.hello("secret"); err == nil {
+		t.Fatal("listener with wrong token is accepted")
+	}
+}
+
+func TestApplyRejectsUpdaterWithoutToken(t *testing.T) {
+	c, impl := authTestClient("secret")
+	defer c.Close()
+
+	if err := c.hello(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.reload(StateUpdate{State: State{}, Generation: 1}); err == nil {
+		t.Fatal("updater without token is allowed to apply state")
+	}
+
+	ok := false
+	if err := c.rc.Call("Configurator.Authenticate", []byte("forged"), &ok); err == nil {
+		t.Fatal("updater with forged proof is authenticated")
+	}
+
+	if impl.applied != 0 {
+		t.Fatalf("updater without token applied %d states", impl.applied)
+	}
+}
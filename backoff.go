@@ -0,0 +1,50 @@
+package marathoner
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff calculates exponentially growing delays between reconnects
+// with random jitter to avoid reconnecting all clients at once
+type Backoff struct {
+	min     time.Duration
+	max     time.Duration
+	factor  float64
+	jitter  float64
+	attempt int
+	rand    *rand.Rand
+}
+
+// NewBackoff creates backoff that starts with min delay and multiplies
+// it by factor after every attempt up to max delay, every delay
+// is randomly reduced by up to jitter fraction of it
+func NewBackoff(min, max time.Duration, factor, jitter float64) *Backoff {
+	return &Backoff{
+		min:    min,
+		max:    max,
+		factor: factor,
+		jitter: jitter,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next returns delay before the next attempt
+func (b *Backoff) Next() time.Duration {
+	d := float64(b.min) * math.Pow(b.factor, float64(b.attempt))
+	if d > float64(b.max) {
+		d = float64(b.max)
+	} else {
+		b.attempt++
+	}
+
+	d -= d * b.jitter * b.rand.Float64()
+
+	return time.Duration(d)
+}
+
+// Reset makes the next delay minimal again
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
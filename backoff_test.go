@@ -0,0 +1,35 @@
+package marathoner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffWithoutJitter(t *testing.T) {
+	b := NewBackoff(time.Second, 5*time.Second, 2, 0)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if d := b.Next(); d != e {
+			t.Fatalf("attempt %d: got %s, expected %s", i+1, d, e)
+		}
+	}
+
+	b.Reset()
+
+	if d := b.Next(); d != time.Second {
+		t.Fatalf("got %s after reset, expected %s", d, time.Second)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := NewBackoff(time.Second, time.Minute, 2, 0.5)
+
+	for i := 0; i < 100; i++ {
+		b.Reset()
+
+		if d := b.Next(); d < time.Second/2 || d > time.Second {
+			t.Fatalf("got %s, expected between %s and %s", d, time.Second/2, time.Second)
+		}
+	}
+}
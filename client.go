@@ -1,42 +1,216 @@
 package marathoner
 
 import (
+	"errors"
 	"log"
 	"net"
 	"net/rpc"
+	"reflect"
+	"strings"
+	"time"
 )
 
 // client is rpc client to update configs on remote servers
 type client struct {
-	name string
-	rc   *rpc.Client
+	name     string
+	addr     string
+	rc       *rpc.Client
+	info     Hello
+	selector Selector
+	last     *StateUpdate
+	result   ApplyResult
+	legacy   bool
+	// applied is the time of state applied by remote server, zero
+	// if it is unknown, generation is the generation of the latest
+	// state that matches it, both are protected by updater's mutex
+	applied    time.Time
+	generation uint64
 }
 
 // newClient create client with given net.Conn
 func newClient(conn net.Conn) *client {
 	return &client{
 		name: conn.RemoteAddr().String(),
+		addr: conn.RemoteAddr().String(),
 		rc:   rpc.NewClient(conn),
 	}
 }
 
-// reload updates config on remote server
-func (c *client) reload(s State) error {
-	reloaded := false
-	err := c.rc.Call("Configurator.Update", s, &reloaded)
+// hello asks remote server for its description, with token remote
+// server and updater prove each other that they know it, token
+// itself is never sent, so rogue servers cannot learn it
+func (c *client) hello(token string) error {
+	nonce, err := newNonce()
 	if err != nil {
 		return err
 	}
 
-	if reloaded {
-		log.Println("reloaded config on " + c.name)
-	} else {
-		log.Println("not reloaded config on " + c.name)
+	h := Hello{}
+	err = c.rc.Call("Configurator.Hello", HelloArgs{Nonce: nonce}, &h)
+	if err != nil {
+		if !missingMethod(err) {
+			return err
+		}
+
+		if token != "" {
+			return errors.New("client " + c.name + " is too old to authenticate with token")
+		}
+
+		log.Printf("client %s is older than 1.11, using legacy protocol\n", c.name)
+		c.legacy = true
+
+		return nil
+	}
+
+	if token != "" {
+		if !proofValid(token, proofListener, nonce, h.Proof) {
+			return errors.New("client " + c.name + " failed to prove that it knows token")
+		}
+
+		ok := false
+		err = c.rc.Call("Configurator.Authenticate", tokenProof(token, proofUpdater, h.Nonce), &ok)
+		if err != nil {
+			return err
+		}
+	}
+
+	h.Nonce = nil
+	h.Proof = nil
+	c.info = h
+
+	if h.Hostname != "" {
+		c.name = h.Hostname + " (" + c.addr + ")"
+	}
+
+	log.Printf("client %s connected, version: %s, configurator: %s, labels: %v\n", c.name, h.Version, h.Configurator, h.Labels)
+
+	c.selector, err = ParseSelector(h.Selector)
+	if err != nil {
+		return err
+	}
+
+	if !c.selector.Empty() {
+		log.Printf("client %s subscribed with selector %q\n", c.name, c.selector)
 	}
 
 	return nil
 }
 
+// ping checks that remote server responds within timeout
+func (c *client) ping(args PingArgs, timeout time.Duration) error {
+	r := false
+	return c.call("Configurator.Ping", args, &r, timeout)
+}
+
+// redirect asks remote server to reconnect to specified updater,
+// empty updater means any other updater, remote server reconnects
+// right away on shutdown and with backoff otherwise
+func (c *client) redirect(updater string, shutdown bool, timeout time.Duration) error {
+	if c.legacy {
+		// old clients reconnect when connection is closed
+		return nil
+	}
+
+	r := false
+	return c.call("Configurator.Redirect", RedirectArgs{Updater: updater, Shutdown: shutdown}, &r, timeout)
+}
+
+// call calls remote method and waits for response up to timeout
+func (c *client) call(method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	call := c.rc.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(timeout):
+		return errors.New("no response in " + timeout.String())
+	}
+}
+
+// reload updates config on remote server with apps matching
+// client's selector, nothing is sent if these apps are unchanged
+func (c *client) reload(s StateUpdate) (ApplyResult, error) {
+	s.State = c.selector.Filter(s.State)
+
+	if c.last != nil && c.last.Stale == s.Stale && reflect.DeepEqual(c.last.State, s.State) {
+		return ApplyResult{Generation: s.Generation}, nil
+	}
+
+	if c.legacy {
+		return c.reloadLegacy(s)
+	}
+
+	r := ApplyResult{}
+	err := c.rc.Call("Configurator.Apply", s, &r)
+	if err != nil {
+		return r, err
+	}
+
+	if r.Error != "" {
+		// state should be sent again, even if it is unchanged
+		c.last = nil
+		log.Printf("error applying generation %d on %s: %s\n", r.Generation, c.name, r.Error)
+		return r, nil
+	}
+
+	if r.Ignored {
+		// state was not applied, it should not be skipped as unchanged
+		c.last = nil
+		log.Printf("generation %d ignored by %s, newer state is applied\n", r.Generation, c.name)
+		return r, nil
+	}
+
+	c.last = &s
+
+	if r.Changed {
+		log.Printf("reloaded config on %s with generation %d in %s\n", c.name, r.Generation, r.Duration)
+	} else {
+		log.Printf("not reloaded config on %s with generation %d\n", c.name, r.Generation)
+	}
+
+	return r, nil
+}
+
+// reloadLegacy updates config on remote server of version before 1.11,
+// which only accepts state and returns errors of implementation
+func (c *client) reloadLegacy(s StateUpdate) (ApplyResult, error) {
+	started := time.Now()
+
+	reloaded := false
+	err := c.rc.Call("Configurator.Update", s.State, &reloaded)
+
+	r := ApplyResult{
+		Generation: s.Generation,
+		Changed:    reloaded,
+		Duration:   time.Since(started),
+	}
+
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok {
+			return r, err
+		}
+
+		c.last = nil
+		r.Error = err.Error()
+		log.Printf("error applying generation %d on %s: %s\n", r.Generation, c.name, r.Error)
+
+		return r, nil
+	}
+
+	c.last = &s
+
+	log.Printf("updated config on %s with generation %d, reloaded: %v\n", c.name, r.Generation, reloaded)
+
+	return r, nil
+}
+
+// missingMethod returns true if error means that remote
+// server does not have called method, because it is older
+func missingMethod(err error) bool {
+	_, ok := err.(rpc.ServerError)
+	return ok && strings.HasPrefix(err.Error(), "rpc: can't find method ")
+}
+
 // Close closes underlying connection of a client
 func (c *client) Close() error {
 	return c.rc.Close()
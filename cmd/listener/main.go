@@ -5,7 +5,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -19,26 +21,206 @@ func main() {
 	c := flag.String("c", "/etc/haproxy/haproxy.cfg", "haproxy config path")
 	b := flag.String("b", "127.0.0.1", "ip address to bind")
 	m := flag.Int("m", 60, "maximum number seconds to keep previous haproxy running")
+	tc := flag.String("tls-cert", "", "tls certificate path")
+	tk := flag.String("tls-key", "", "tls key path")
+	ta := flag.String("tls-ca", "", "tls ca certificate path to verify peers")
+	tt := flag.String("token", "", "shared token to authenticate updaters and listeners")
+	hb := flag.Duration("heartbeat-timeout", 0, "disconnect from silent updater after timeout, 0 to disable")
+	nl := flag.String("labels", "", "labels of this node reported to updaters, like key=value,key=value")
+	ns := flag.Int("sessions", 1, "number of updaters to be connected to simultaneously")
+	bmin := flag.Duration("backoff-min", time.Second, "minimum delay between reconnects to updaters")
+	bmax := flag.Duration("backoff-max", time.Minute, "maximum delay between reconnects to updaters")
+	bf := flag.Float64("backoff-factor", 2, "multiplier of delay between reconnects after every failure")
+	bj := flag.Float64("backoff-jitter", 0.5, "fraction of delay between reconnects to randomize")
+	cf := flag.String("cache", "", "file to cache applied state to start haproxy without updaters")
+	sl := flag.String("status", "", "listen for status http requests, disabled if empty")
+	sa := flag.Duration("max-age", 0, "max age of applied state for health check, 0 to disable")
+	rs := flag.String("runtime-socket", "", "haproxy stats socket to update servers without reloads")
+	rn := flag.Int("slots", 8, "minimum number of server slots per app for runtime updates")
+	mw := flag.Bool("master-worker", false, "run haproxy in master-worker mode as a child process")
+	mc := flag.String("master-socket", "", "haproxy master cli socket to track old workers")
+	hp := flag.Int("http-port", 0, "port of shared http frontend for virtual hosts, 0 to disable")
+	cd := flag.String("certs", "", "directory with certificates to terminate tls for apps")
+	ci := flag.Duration("certs-interval", time.Minute, "interval to check certificates for changes, 0 to disable")
+	ti := flag.Duration("template-interval", 10*time.Second, "interval to check template for changes, 0 to disable")
+	outs := outputFlags{}
+	flag.Var(&outs, "o", "additional output rendered from state, like template:file, can be repeated")
+	cs := flag.String("configurators", "haproxy", "comma separated configurators to update: haproxy, logger")
+	s := flag.String("s", "", "label selector of apps to receive, like key=value,key!=value")
 	flag.Parse()
 
-	if *p == "" || *t == "" {
-		flag.PrintDefaults()
-		os.Exit(1)
+	impls := []marathoner.ConfiguratorImplementation{}
+	optional := []marathoner.ConfiguratorImplementation{}
+	for _, name := range strings.Split(*cs, ",") {
+		switch strings.TrimSpace(name) {
+		case "haproxy":
+			if *p == "" || *t == "" {
+				flag.PrintDefaults()
+				os.Exit(1)
+			}
+
+			timeout := time.Duration(*m) * time.Second
+
+			ct, err := readTemplate(*t)
+			if err != nil {
+				log.Fatal("error reading template:", err)
+			}
+
+			hc := marathoner.NewHaproxyConfigurator(ct, *c, *b, *p, timeout)
+			if *rs != "" {
+				hc.SetRuntimeAPI(*rs, *rn)
+			}
+
+			if *mw {
+				hc.SetMasterWorker(*mc)
+			}
+
+			hc.SetHTTPPort(*hp)
+
+			if *cd != "" {
+				hc.SetCertificates(*cd, *ci)
+			}
+
+			for _, o := range outs {
+				parts := strings.SplitN(o, ":", 2)
+				if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+					log.Fatal("invalid output, expected template:file: ", o)
+				}
+
+				ot, err := readTemplate(parts[0])
+				if err != nil {
+					log.Fatal("error reading template:", err)
+				}
+
+				hc.AddOutput(ot, parts[1])
+			}
+
+			go watchTemplate(hc, *t, *ti)
+
+			impls = append(impls, hc)
+		case "logger":
+			optional = append(optional, marathoner.NewStdoutStateLogger())
+		default:
+			log.Fatal("unknown configurator: ", name)
+		}
 	}
 
-	timeout := time.Duration(*m) * time.Second
+	// failures of loggers should not fail applies of configurators
+	var conf marathoner.ConfiguratorImplementation
+	if len(impls)+len(optional) == 1 {
+		conf = append(impls, optional...)[0]
+	} else {
+		mc := marathoner.NewMultiConfigurator(impls...)
+		for _, o := range optional {
+			mc.AddOptional(o)
+		}
 
-	ct, err := readTemplate(*t)
+		conf = mc
+	}
+
+	l := marathoner.NewListener(strings.Split(*u, ","), conf)
+	ls, err := marathoner.ParseSelector(*s)
 	if err != nil {
-		log.Fatal("error reading template:", err)
+		log.Fatal("error parsing selector:", err)
 	}
 
-	conf := marathoner.NewHaproxyConfigurator(ct, *c, *b, *p, timeout)
+	l.SetSelector(ls)
+
+	labels, err := marathoner.ParseLabels(*nl)
+	if err != nil {
+		log.Fatal("error parsing labels:", err)
+	}
+
+	l.SetLabels(labels)
+
+	if *tc != "" {
+		tlsConf, err := marathoner.NewTLSConfig(*tc, *tk, *ta)
+		if err != nil {
+			log.Fatal("error loading tls config:", err)
+		}
+
+		l.SetTLSConfig(tlsConf)
+	}
+
+	l.SetToken(*tt)
+	l.SetHeartbeatTimeout(*hb)
+	l.SetSessions(*ns)
+	l.SetBackoff(*bmin, *bmax, *bf, *bj)
+	l.SetCacheFile(*cf)
+
+	if *sl != "" {
+		go func() {
+			log.Fatal(l.ListenForStatus(*sl, *sa))
+		}()
+	}
 
-	l := marathoner.NewListener(strings.Split(*u, ","), conf)
 	l.Start()
 }
 
+// outputFlags is a list of template:file pairs from repeated flags
+type outputFlags []string
+
+func (o *outputFlags) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *outputFlags) Set(v string) error {
+	*o = append(*o, v)
+	return nil
+}
+
+// watchTemplate replaces template of haproxy configurator when template
+// file changes or on sighup, broken templates are logged and ignored
+func watchTemplate(hc *marathoner.HaproxyConfigurator, file string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.Tick(interval)
+	}
+
+	prev, err := os.Stat(file)
+	if err != nil {
+		log.Println("error checking template:", err)
+	}
+
+	for {
+		select {
+		case <-hup:
+			log.Println("received sighup, reloading template")
+		case <-tick:
+			fi, err := os.Stat(file)
+			if err != nil {
+				log.Println("error checking template:", err)
+				continue
+			}
+
+			if prev != nil && fi.ModTime().Equal(prev.ModTime()) && fi.Size() == prev.Size() {
+				continue
+			}
+
+			prev = fi
+
+			log.Println("template changed, reloading template")
+		}
+
+		ct, err := readTemplate(file)
+		if err != nil {
+			log.Println("error reading template, keeping previous one:", err)
+			continue
+		}
+
+		err = hc.SetTemplate(ct)
+		if err != nil {
+			log.Println("error applying template, keeping previous one:", err)
+			continue
+		}
+
+		log.Println("template reloaded")
+	}
+}
+
 // readTemplate reads haproxy config template from a file
 func readTemplate(file string) (*template.Template, error) {
 	tf, err := ioutil.ReadFile(file)
@@ -46,5 +228,5 @@ func readTemplate(file string) (*template.Template, error) {
 		return nil, err
 	}
 
-	return template.New("config").Parse(string(tf))
+	return template.New("config").Funcs(marathoner.TemplateFuncs()).Parse(string(tf))
 }
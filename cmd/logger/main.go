@@ -2,26 +2,58 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"github.com/bobrik/marathoner"
-	"os"
+	"log"
 	"strings"
 	"time"
 )
 
-type stdOutStateLogger struct{}
-
-func (s stdOutStateLogger) Write(p []byte) (n int, err error) {
-	t := time.Now().Format("2006-01-02T15:04:05.999999999Z0700") // iso8601
-	return os.Stdout.Write([]byte(fmt.Sprintf("%s: %s\n", t, string(p))))
-}
-
 func main() {
 	u := flag.String("u", "127.0.0.1:7676", "updater location")
+	tc := flag.String("tls-cert", "", "tls certificate path")
+	tk := flag.String("tls-key", "", "tls key path")
+	ta := flag.String("tls-ca", "", "tls ca certificate path to verify peers")
+	tt := flag.String("token", "", "shared token to authenticate updaters and listeners")
+	hb := flag.Duration("heartbeat-timeout", 0, "disconnect from silent updater after timeout, 0 to disable")
+	nl := flag.String("labels", "", "labels of this node reported to updaters, like key=value,key=value")
+	ns := flag.Int("sessions", 1, "number of updaters to be connected to simultaneously")
+	bmin := flag.Duration("backoff-min", time.Second, "minimum delay between reconnects to updaters")
+	bmax := flag.Duration("backoff-max", time.Minute, "maximum delay between reconnects to updaters")
+	bf := flag.Float64("backoff-factor", 2, "multiplier of delay between reconnects after every failure")
+	bj := flag.Float64("backoff-jitter", 0.5, "fraction of delay between reconnects to randomize")
+	s := flag.String("s", "", "label selector of apps to receive, like key=value,key!=value")
 	flag.Parse()
 
-	c := marathoner.NewStateLogger(stdOutStateLogger{})
+	c := marathoner.NewStdoutStateLogger()
 
 	l := marathoner.NewListener(strings.Split(*u, ","), c)
+	ls, err := marathoner.ParseSelector(*s)
+	if err != nil {
+		log.Fatal("error parsing selector:", err)
+	}
+
+	l.SetSelector(ls)
+
+	labels, err := marathoner.ParseLabels(*nl)
+	if err != nil {
+		log.Fatal("error parsing labels:", err)
+	}
+
+	l.SetLabels(labels)
+
+	if *tc != "" {
+		tlsConf, err := marathoner.NewTLSConfig(*tc, *tk, *ta)
+		if err != nil {
+			log.Fatal("error loading tls config:", err)
+		}
+
+		l.SetTLSConfig(tlsConf)
+	}
+
+	l.SetToken(*tt)
+	l.SetHeartbeatTimeout(*hb)
+	l.SetSessions(*ns)
+	l.SetBackoff(*bmin, *bmax, *bf, *bj)
+
 	l.Start()
 }
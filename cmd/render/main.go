@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bobrik/marathoner"
+)
+
+func main() {
+	t := flag.String("t", "", "config template path")
+	f := flag.String("f", "", "state file saved by updater or listener, or logger output, - for stdin")
+	u := flag.String("u", "", "updater location to get state from instead of file")
+	b := flag.String("b", "127.0.0.1", "ip address to bind")
+	m := flag.Int("m", 60, "maximum number seconds to keep previous haproxy running")
+	tc := flag.String("tls-cert", "", "tls certificate path")
+	tk := flag.String("tls-key", "", "tls key path")
+	ta := flag.String("tls-ca", "", "tls ca certificate path to verify peers")
+	tt := flag.String("token", "", "shared token to authenticate updaters and listeners")
+	wt := flag.Duration("timeout", 30*time.Second, "timeout to receive state from updater")
+	rn := flag.Int("slots", 0, "minimum number of server slots per app, as with listener -runtime-socket")
+	mw := flag.Bool("master-worker", false, "render config for haproxy in master-worker mode")
+	hp := flag.Int("http-port", 0, "port of shared http frontend for virtual hosts, 0 to disable")
+	cd := flag.String("certs", "", "directory with certificates to terminate tls for apps")
+	d := flag.String("diff", "", "print unified diff against specified config instead of config")
+	c := flag.Bool("check", false, "check rendered config with haproxy -c")
+	s := flag.String("s", "", "label selector of apps to render, like key=value,key!=value")
+	flag.Parse()
+
+	if *t == "" || (*f == "") == (*u == "") {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	ct, err := readTemplate(*t)
+	if err != nil {
+		log.Fatal("error reading template:", err)
+	}
+
+	ls, err := marathoner.ParseSelector(*s)
+	if err != nil {
+		log.Fatal("error parsing selector:", err)
+	}
+
+	var state marathoner.State
+	if *f != "" {
+		state, err = readState(*f)
+	} else {
+		state, err = receiveState(*u, ls, *tc, *tk, *ta, *tt, *wt)
+	}
+
+	if err != nil {
+		log.Fatal("error getting state:", err)
+	}
+
+	hc := marathoner.NewHaproxyConfigurator(ct, "", *b, "", time.Duration(*m)*time.Second)
+	if *rn > 0 {
+		hc.SetRuntimeAPI("", *rn)
+	}
+
+	if *mw {
+		hc.SetMasterWorker("")
+	}
+
+	hc.SetHTTPPort(*hp)
+
+	if *cd != "" {
+		hc.SetCertificates(*cd, 0)
+	}
+
+	config, err := hc.Render(ls.Filter(state))
+	if err != nil {
+		log.Fatal("error rendering config:", err)
+	}
+
+	code := 0
+
+	if *c {
+		err = checkConfig(config)
+		if err != nil {
+			log.Println(err)
+			code = 1
+		}
+	}
+
+	if *d == "" {
+		os.Stdout.Write(config)
+		os.Exit(code)
+	}
+
+	current, err := ioutil.ReadFile(*d)
+	if err != nil {
+		log.Fatal("error reading config to compare with:", err)
+	}
+
+	diff := marathoner.UnifiedDiff(string(current), string(config), *d, "rendered")
+	if diff != "" {
+		os.Stdout.WriteString(diff)
+		code = 1
+	}
+
+	os.Exit(code)
+}
+
+// readState reads state from file or stdin
+func readState(file string) (marathoner.State, error) {
+	var b []byte
+	var err error
+
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return marathoner.ParseState(b)
+}
+
+// receiveState connects to updaters as listener and returns the first
+// state received from them or error if nothing is received within timeout
+func receiveState(updaters string, s marathoner.Selector, cert, key, ca, token string, timeout time.Duration) (marathoner.State, error) {
+	c := stateReceiver{make(chan marathoner.State, 1)}
+
+	l := marathoner.NewListener(strings.Split(updaters, ","), c)
+	l.SetSelector(s)
+	l.SetToken(token)
+
+	if cert != "" {
+		tlsConf, err := marathoner.NewTLSConfig(cert, key, ca)
+		if err != nil {
+			return nil, err
+		}
+
+		l.SetTLSConfig(tlsConf)
+	}
+
+	go l.Start()
+
+	select {
+	case state := <-c.states:
+		return state, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no state received from updaters in %s", timeout)
+	}
+}
+
+// stateReceiver is configurator implementation that
+// passes received states to a channel
+type stateReceiver struct {
+	states chan marathoner.State
+}
+
+func (c stateReceiver) Update(s marathoner.State, r *bool) error {
+	select {
+	case c.states <- s:
+	default:
+	}
+
+	*r = true
+	return nil
+}
+
+// checkConfig checks rendered config with haproxy
+func checkConfig(config []byte) error {
+	temp, err := ioutil.TempFile("", "marathoner-render")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(config)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command("haproxy", "-c", "-f", temp.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error checking config: %s, output: %s", err, string(out))
+	}
+
+	return nil
+}
+
+// readTemplate reads haproxy config template from a file
+func readTemplate(file string) (*template.Template, error) {
+	tf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New("config").Funcs(marathoner.TemplateFuncs()).Parse(string(tf))
+}
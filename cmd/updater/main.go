@@ -4,7 +4,10 @@ import (
 	"flag"
 	"github.com/bobrik/marathoner"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -12,14 +15,71 @@ func main() {
 	l := flag.String("l", "0.0.0.0:7676", "listen for clients")
 	m := flag.String("m", "http://127.0.0.1:8080", "maraton location")
 	i := flag.Float64("i", 1.0, "update interval")
+	gf := flag.Float64("guard-fraction", 0, "max fraction of apps or tasks to remove at once, 0 to disable")
+	gp := flag.Int("guard-polls", 5, "consecutive polls to accept state exceeding guard fraction")
+	tc := flag.String("tls-cert", "", "tls certificate path")
+	tk := flag.String("tls-key", "", "tls key path")
+	ta := flag.String("tls-ca", "", "tls ca certificate path to verify peers")
+	tt := flag.String("token", "", "shared token to authenticate updaters and listeners")
+	h := flag.String("http", "", "listen for http clients, disabled if empty")
+	hi := flag.Duration("heartbeat-interval", 0, "interval to ping clients, 0 to disable")
+	ht := flag.Duration("heartbeat-timeout", 5*time.Second, "disconnect client that failed to respond to ping")
+	r := flag.String("redirect", "", "updater to redirect clients to on shutdown, any other if empty")
+	mc := flag.Int("max-clients", 0, "maximum number of clients to serve, 0 is unlimited")
+	d := flag.Duration("drain", 10*time.Second, "time to wait for clients to disconnect on shutdown")
+	cp := flag.Float64("canary-percent", 0, "percentage of clients to get new state first, 0 to disable")
+	cw := flag.Duration("canary-wait", 30*time.Second, "time to wait for canaries to apply new state")
+	f := flag.String("f", "", "file to persist last distributed state")
 	flag.Parse()
 
 	u := marathoner.NewUpdater()
+	u.SetRemovalGuard(*gf, *gp)
+
+	if *tc != "" {
+		tlsConf, err := marathoner.NewTLSConfig(*tc, *tk, *ta)
+		if err != nil {
+			log.Fatal("error loading tls config:", err)
+		}
+
+		u.SetTLSConfig(tlsConf)
+	}
+
+	u.SetToken(*tt)
+	u.SetHeartbeat(*hi, *ht)
+	u.SetRedirect(*r)
+	u.SetMaxClients(*mc)
+	u.SetCanary(*cp, *cw)
+
+	if *f != "" {
+		err := u.SetStateFile(*f)
+		if err != nil {
+			log.Fatal("error loading state:", err)
+		}
+	}
 
 	go u.ListenForUpdates(strings.Split(*m, ","), time.Duration(*i)*time.Second)
 
+	if *h != "" {
+		go func() {
+			log.Fatal(u.ListenForHTTP(*h))
+		}()
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+		log.Println("received signal", <-sig)
+
+		u.Shutdown(*d)
+		os.Exit(0)
+	}()
+
 	err := u.ListenForClients(*l)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// shutdown is in progress, it exits when clients are drained
+	select {}
 }
@@ -1,17 +1,226 @@
 package marathoner
 
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// errUnauthenticated is returned to updaters that failed to provide valid token
+var errUnauthenticated = errors.New("updater is not authenticated")
+
+// errStateIgnored is returned by apply function if state was not applied,
+// because newer state is already applied, it is reported to updater
+var errStateIgnored = errors.New("newer state is already applied")
+
 // ConfiguratorImplementation is something that updates config with a new state
 type ConfiguratorImplementation interface {
 	Update(State, *bool) error
 }
 
+// HelloArgs is sent by updater to listener to start a session
+type HelloArgs struct {
+	// Nonce is a challenge for listener to prove that it knows token
+	Nonce []byte
+}
+
+// RedirectArgs is sent by updater to ask listener to reconnect
+type RedirectArgs struct {
+	// Updater is updater to reconnect to, empty means any other updater
+	Updater string
+	// Shutdown is true if updater is shutting down and listener can
+	// reconnect right away, otherwise updater has too many clients
+	// and listener should reconnect with backoff
+	Shutdown bool
+}
+
+// Hello describes listener to updater, updater asks for it
+// right after connection is established
+type Hello struct {
+	// Nonce is a challenge for updater to prove that it knows token
+	Nonce []byte
+	// Proof is a proof that listener knows token, empty without token
+	Proof []byte
+	// Selector is a label selector of apps listener is interested in
+	Selector string
+	// Hostname is a hostname of listener
+	Hostname string
+	// Version is marathoner version of listener
+	Version string
+	// Configurator is a type of configurator implementation
+	Configurator string
+	// Labels are arbitrary labels of listener node
+	Labels map[string]string
+}
+
 // Configurator can update config of a specific implementation.
 // It is only needed to keep name static with different implementations.
 type Configurator struct {
-	impl ConfiguratorImplementation
+	apply    func(StateUpdate, *bool) error
+	hello    Hello
+	token    string
+	nonce    []byte
+	mutex    sync.Mutex
+	authn    bool
+	updated  bool
+	redirect func(RedirectArgs)
+	confirm  func(PingArgs)
+}
+
+// newConfigurator creates configurator that applies state updates
+// with specified function and introduces itself with specified hello,
+// updaters must prove that they know token if it is not empty
+func newConfigurator(apply func(StateUpdate, *bool) error, hello Hello, token string) *Configurator {
+	return &Configurator{
+		apply: apply,
+		hello: hello,
+		token: token,
+		authn: token == "",
+	}
+}
+
+// Hello returns description of listener with proof that listener
+// knows token and challenge for updater to prove the same.
+func (c *Configurator) Hello(a HelloArgs, r *Hello) error {
+	*r = c.hello
+
+	if c.token == "" {
+		return nil
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.nonce = nonce
+	c.mutex.Unlock()
+
+	r.Nonce = nonce
+	r.Proof = tokenProof(c.token, proofListener, a.Nonce)
+
+	return nil
+}
+
+// Authenticate checks proof that updater knows token,
+// challenge from the last hello can only be answered once.
+func (c *Configurator) Authenticate(proof []byte, r *bool) error {
+	c.mutex.Lock()
+	nonce := c.nonce
+	c.nonce = nil
+	c.mutex.Unlock()
+
+	if c.token == "" || nonce == nil || !proofValid(c.token, proofUpdater, nonce, proof) {
+		log.Println("updater provided invalid token proof")
+		return errUnauthenticated
+	}
+
+	c.mutex.Lock()
+	c.authn = true
+	c.mutex.Unlock()
+
+	*r = true
+	return nil
+}
+
+// Ping lets updater know that listener is alive and lets
+// listener know that served state is still actual.
+func (c *Configurator) Ping(a PingArgs, r *bool) error {
+	if c.confirm != nil {
+		c.confirm(a)
+	}
+
+	*r = true
+	return nil
+}
+
+// Redirect asks listener to reconnect to specified updater,
+// empty updater means that any other updater is fine.
+func (c *Configurator) Redirect(a RedirectArgs, r *bool) error {
+	c.mutex.Lock()
+	authn := c.authn
+	c.mutex.Unlock()
+
+	if !authn {
+		return errUnauthenticated
+	}
+
+	if c.redirect != nil {
+		c.redirect(a)
+	}
+
+	*r = true
+	return nil
 }
 
-// Update updates configuration on implementation.
+// Update updates configuration on implementation with state sent by
+// updaters of versions before 1.11, which call it without handshake.
+// It is kept for rolling upgrades and only works without token.
 func (c *Configurator) Update(s State, r *bool) error {
-	return c.impl.Update(s, r)
+	result := ApplyResult{}
+
+	err := c.Apply(StateUpdate{State: s, Time: time.Now()}, &result)
+	if err != nil {
+		return err
+	}
+
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+
+	*r = result.Changed
+	return nil
+}
+
+// Apply updates configuration on implementation and reports result.
+// Errors of implementation are reported in result, not returned.
+func (c *Configurator) Apply(u StateUpdate, r *ApplyResult) error {
+	c.mutex.Lock()
+	authn := c.authn
+	c.mutex.Unlock()
+
+	if !authn {
+		return errUnauthenticated
+	}
+
+	if u.Stale {
+		log.Printf("received stale state from updater, age: %s\n", u.Age())
+	}
+
+	started := time.Now()
+
+	changed := false
+	err := c.apply(u, &changed)
+
+	*r = ApplyResult{
+		Generation: u.Generation,
+		Changed:    changed,
+		Duration:   time.Since(started),
+	}
+
+	if err == errStateIgnored {
+		r.Ignored = true
+		return nil
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+		return nil
+	}
+
+	c.mutex.Lock()
+	c.updated = true
+	c.mutex.Unlock()
+
+	return nil
+}
+
+// healthy returns true if at least one update was applied.
+func (c *Configurator) healthy() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.updated
 }
@@ -0,0 +1,195 @@
+package marathoner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around changes in diffs
+const diffContext = 3
+
+// maxDiffCells limits the size of the table to find minimal diff,
+// larger changes are shown as removal of old lines and addition of new ones
+const maxDiffCells = 10000000
+
+// diffOp is a line of diff, kind is one of ' ', '-' and '+'
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// UnifiedDiff returns differences between two texts in unified format
+// with the specified names of texts, empty string means no differences
+func UnifiedDiff(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	b := strings.Builder{}
+	b.WriteString("--- " + fromName + "\n")
+	b.WriteString("+++ " + toName + "\n")
+
+	// positions of lines in both texts before every op
+	fromPos := make([]int, len(ops)+1)
+	toPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		fromPos[i+1], toPos[i+1] = fromPos[i], toPos[i]
+
+		if op.kind != '+' {
+			fromPos[i+1]++
+		}
+
+		if op.kind != '-' {
+			toPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// extend hunk while changes are close enough to each other
+		end, equal := i, 0
+		for j := i; j < len(ops) && equal <= 2*diffContext; j++ {
+			if ops[j].kind == ' ' {
+				equal++
+			} else {
+				end, equal = j, 0
+			}
+		}
+
+		end += diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		b.WriteString(fmt.Sprintf("@@ -%s +%s @@\n",
+			hunkRange(fromPos[start], fromPos[end]-fromPos[start]),
+			hunkRange(toPos[start], toPos[end]-toPos[start])))
+
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line + "\n")
+		}
+
+		i = end
+	}
+
+	return b.String()
+}
+
+// hunkRange formats range of lines for hunk header
+func hunkRange(pos, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", pos)
+	}
+
+	return fmt.Sprintf("%d,%d", pos+1, count)
+}
+
+// splitLines splits text into lines without line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns ops turning lines a into lines b, common prefix and
+// suffix are skipped and the rest is compared with longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []diffOp{}
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	return ops
+}
+
+// diffMiddle returns ops turning lines a into lines b
+func diffMiddle(a, b []string) []diffOp {
+	ops := []diffOp{}
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+
+		return ops
+	}
+
+	// lcs[i][j] is the length of common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
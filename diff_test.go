@@ -0,0 +1,48 @@
+package marathoner
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	cases := map[string]struct {
+		from     string
+		to       string
+		expected string
+	}{
+		"same": {
+			from:     "a\nb\n",
+			to:       "a\nb\n",
+			expected: "",
+		},
+		"change": {
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: "--- old\n+++ new\n" +
+				"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		"separate hunks": {
+			from: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			to:   "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			expected: "--- old\n+++ new\n" +
+				"@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n" +
+				"@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+		"from empty": {
+			from: "",
+			to:   "a\n",
+			expected: "--- old\n+++ new\n" +
+				"@@ -0,0 +1,1 @@\n+a\n",
+		},
+		"insert": {
+			from: "a\nc\n",
+			to:   "a\nb\nc\n",
+			expected: "--- old\n+++ new\n" +
+				"@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+	}
+
+	for name, c := range cases {
+		if d := UnifiedDiff(c.from, c.to, "old", "new"); d != c.expected {
+			t.Errorf("%s: got diff:\n%s\nexpected:\n%s", name, d, c.expected)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package marathoner
+
+import (
+	"fmt"
+	"log"
+)
+
+// removalGuard protects listeners from states that remove too many
+// apps or tasks at once, which usually means that marathon returned
+// incomplete response (during leader election, for example)
+type removalGuard struct {
+	fraction float64
+	polls    int
+	pending  int
+}
+
+// newRemovalGuard creates guard that holds back states removing more
+// than specified fraction of apps or tasks, until such state is seen
+// for the specified number of consecutive polls
+func newRemovalGuard(fraction float64, polls int) *removalGuard {
+	return &removalGuard{
+		fraction: fraction,
+		polls:    polls,
+	}
+}
+
+// check returns nil if next state can be distributed
+// in place of current state and error otherwise
+func (g *removalGuard) check(current, next State) error {
+	if g == nil || current == nil || g.fraction <= 0 {
+		return nil
+	}
+
+	reason := g.exceeded(current, next)
+	if reason == "" {
+		g.pending = 0
+		return nil
+	}
+
+	g.pending++
+	if g.pending >= g.polls {
+		log.Printf("removal guard: %s for %d consecutive polls, accepting state\n", reason, g.pending)
+		g.pending = 0
+		return nil
+	}
+
+	return fmt.Errorf("removal guard tripped: %s (%d of %d polls)", reason, g.pending, g.polls)
+}
+
+// reset forgets held back state, it is called when marathon
+// returns the current state again, so only consecutive polls count
+func (g *removalGuard) reset() {
+	if g != nil {
+		g.pending = 0
+	}
+}
+
+// exceeded returns description of the exceeded limit or empty string
+func (g *removalGuard) exceeded(current, next State) string {
+	apps, tasks, removedApps, removedTasks := 0, 0, 0, 0
+
+	for n, a := range current {
+		apps++
+		tasks += len(a.Tasks)
+
+		na, ok := next[n]
+		if !ok {
+			removedApps++
+			removedTasks += len(a.Tasks)
+			continue
+		}
+
+		ids := map[string]bool{}
+		for _, t := range na.Tasks {
+			ids[t.ID] = true
+		}
+
+		for _, t := range a.Tasks {
+			if !ids[t.ID] {
+				removedTasks++
+			}
+		}
+	}
+
+	if apps > 0 && float64(removedApps)/float64(apps) > g.fraction {
+		return fmt.Sprintf("%d of %d apps removed", removedApps, apps)
+	}
+
+	if tasks > 0 && float64(removedTasks)/float64(tasks) > g.fraction {
+		return fmt.Sprintf("%d of %d tasks removed", removedTasks, tasks)
+	}
+
+	return ""
+}
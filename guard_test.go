@@ -0,0 +1,88 @@
+package marathoner
+
+import "testing"
+
+func guardTestState(apps, tasks int) State {
+	s := State{}
+
+	for i := 0; i < apps; i++ {
+		a := App{Name: string(rune('a' + i))}
+		for j := 0; j < tasks; j++ {
+			a.Tasks = append(a.Tasks, Task{ID: a.Name + string(rune('0'+j))})
+		}
+
+		s[a.Name] = a
+	}
+
+	return s
+}
+
+func TestRemovalGuardAllowsSmallChanges(t *testing.T) {
+	g := newRemovalGuard(0.5, 3)
+
+	err := g.check(guardTestState(4, 2), guardTestState(3, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemovalGuardHoldsMassRemoval(t *testing.T) {
+	g := newRemovalGuard(0.5, 3)
+
+	current := guardTestState(4, 2)
+	next := guardTestState(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if g.check(current, next) == nil {
+			t.Fatalf("mass removal accepted on poll %d", i+1)
+		}
+	}
+
+	if err := g.check(current, next); err != nil {
+		t.Fatalf("mass removal not accepted after 3 polls: %s", err)
+	}
+}
+
+func TestRemovalGuardHoldsTaskRemoval(t *testing.T) {
+	g := newRemovalGuard(0.5, 2)
+
+	if g.check(guardTestState(2, 4), guardTestState(2, 1)) == nil {
+		t.Fatal("mass task removal accepted")
+	}
+
+	if err := g.check(guardTestState(2, 4), guardTestState(2, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.pending != 0 {
+		t.Fatalf("pending counter is %d after good state, expected 0", g.pending)
+	}
+}
+
+func TestRemovalGuardResetsOnCurrentState(t *testing.T) {
+	u := NewUpdater()
+	u.SetRemovalGuard(0.5, 3)
+
+	good := guardTestState(4, 2)
+	bad := guardTestState(1, 2)
+
+	u.update(good)
+
+	for i := 0; i < 3; i++ {
+		u.update(bad)
+
+		if !u.GuardTripped() {
+			t.Fatalf("guard is not tripped on bad poll %d", i+1)
+		}
+
+		u.update(good)
+
+		if u.GuardTripped() {
+			t.Fatalf("guard is still tripped after good poll %d", i+1)
+		}
+	}
+
+	if len(u.state.State) != 4 {
+		t.Fatalf("interleaved bad polls were accepted, got %d apps", len(u.state.State))
+	}
+}
@@ -1,11 +1,13 @@
 package marathoner
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,21 +19,39 @@ import (
 
 // haproxyConfigContext defines context for haproxy config template
 type haproxyConfigContext struct {
-	Bind string
-	Apps map[int]HaproxyApp
+	Bind          string
+	Apps          map[int]HaproxyApp
+	MasterWorker  bool
+	HardStopAfter string
+	HTTPPort      int
+	VHosts        []HaproxyVHost
+	Certificates  string
 }
 
-// HaproxyApp has port and list of servers for that port
+// HaproxyApp has port and list of servers for that port,
+// along with id of marathon app, index and name of the port
 type HaproxyApp struct {
-	Port    int
-	Servers []HaproxyServer
-	Labels  map[string]string
+	ID        string
+	Port      int
+	PortIndex int
+	PortName  string
+	Backend   string
+	Servers   []HaproxyServer
+	Slots     []HaproxySlot
+	Labels    map[string]string
+	Options   HaproxyOptions
+	VHosts    []HaproxyVHost
+	Errors    []string
 }
 
-// HaproxyServer has host and port where working service is located
+// HaproxyServer has host and port where working service is located,
+// along with id of marathon task and its timestamps
 type HaproxyServer struct {
-	Host string
-	Port int
+	Host      string
+	Port      int
+	TaskID    string
+	StagedAt  string
+	StartedAt string
 }
 
 // HaproxyConfigurator implements ConfiguratorImplementation for haproxy
@@ -44,6 +64,13 @@ type HaproxyConfigurator struct {
 	bind     string
 	pidfile  string
 	timeout  time.Duration
+	socket   string
+	slots    int
+	master   bool
+	cli      string
+	http     int
+	certs    string
+	outputs  []haproxyOutput
 }
 
 // NewHaproxyConfigurator creates configurator with specified config template,
@@ -62,6 +89,25 @@ func NewHaproxyConfigurator(template *template.Template, conf string, bind strin
 	}
 }
 
+// SetRuntimeAPI makes configurator allocate at least specified number
+// of server slots for every app and apply changes of servers through
+// haproxy runtime api on specified stats socket without reloads,
+// haproxy is only reloaded if something else has changed
+func (c *HaproxyConfigurator) SetRuntimeAPI(socket string, slots int) {
+	c.mutex.Lock()
+	c.socket = socket
+	c.slots = slots
+	c.mutex.Unlock()
+}
+
+// SetHTTPPort sets port of shared http frontend that routes requests
+// to http apps by host header according to their virtual hosts
+func (c *HaproxyConfigurator) SetHTTPPort(port int) {
+	c.mutex.Lock()
+	c.http = port
+	c.mutex.Unlock()
+}
+
 // Update runs actually update and logs error if it happens
 func (c *HaproxyConfigurator) Update(s State, r *bool) error {
 	err := c.update(s, r)
@@ -80,66 +126,306 @@ func (c *HaproxyConfigurator) update(s State, r *bool) error {
 	log.Println("received update request")
 
 	apps := stateToApps(s)
+	refuseTLSApps(apps, c.certs)
+	assignSlots(c.apps, apps, c.slots)
+
 	if reflect.DeepEqual(apps, c.apps) {
 		log.Println("state is the same, not doing any updates")
 		*r = false
 		return nil
 	}
 
+	for _, app := range apps {
+		for _, e := range app.Errors {
+			log.Printf("ignoring label of app on port %d: %s\n", app.Port, e)
+		}
+	}
+
+	if c.socket != "" && c.apps != nil && sameStructure(c.apps, apps) {
+		err := c.updateRuntime(apps)
+		if err == nil {
+			log.Println("servers updated through runtime api")
+			*r = true
+			return nil
+		}
+
+		log.Println("error updating servers through runtime api, reloading:", err)
+
+		// runtime state of haproxy is unknown now
+		c.apps = nil
+	}
+
+	files, err := c.renderConfig(apps)
+	if err != nil {
+		return err
+	}
+
+	err = c.applyConfig(files)
+	if err != nil {
+		return err
+	}
+
 	c.apps = apps
 
-	err := c.updateConfig()
+	*r = true
+	return nil
+}
+
+// Render renders haproxy config for specified state without applying it
+func (c *HaproxyConfigurator) Render(s State) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	apps := stateToApps(s)
+	refuseTLSApps(apps, c.certs)
+	assignSlots(nil, apps, c.slots)
+
+	return c.renderTemplate(c.template, apps)
+}
+
+// Refresh renders config for the last applied state again and reloads
+// haproxy, it is needed when something outside of state has changed
+func (c *HaproxyConfigurator) Refresh() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.apps == nil {
+		return nil
+	}
+
+	files, err := c.renderConfig(c.apps)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 
-	log.Println("config updated")
+	return c.applyConfig(files)
+}
+
+// SetTemplate replaces config template, config for the last applied
+// state is rendered with new template and haproxy is reloaded if config
+// has changed, template is rejected if it fails to execute or to apply
+func (c *HaproxyConfigurator) SetTemplate(t *template.Template) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	apps := c.apps
+	if apps == nil {
+		apps = map[int]HaproxyApp{}
+	}
+
+	files, err := c.renderFiles(t, apps)
+	if err != nil {
+		return err
+	}
+
+	if c.apps != nil {
+		changed, err := filesChanged(files)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			err = c.applyConfig(files)
+			if err != nil {
+				return err
+			}
+		} else {
+			log.Println("config is the same with new template, not reloading")
+		}
+	}
+
+	c.template = t
+
+	return nil
+}
+
+// renderConfig renders haproxy config and additional outputs for specified apps
+func (c *HaproxyConfigurator) renderConfig(apps map[int]HaproxyApp) ([]haproxyFile, error) {
+	return c.renderFiles(c.template, apps)
+}
+
+// renderFiles renders haproxy config with specified template and additional
+// outputs with their own templates, haproxy config is always the first file
+func (c *HaproxyConfigurator) renderFiles(t *template.Template, apps map[int]HaproxyApp) ([]haproxyFile, error) {
+	config, err := c.renderTemplate(t, apps)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []haproxyFile{{file: c.conf, data: config}}
+
+	for _, o := range c.outputs {
+		data, err := c.renderTemplate(o.template, apps)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering %s: %s", o.file, err)
+		}
+
+		files = append(files, haproxyFile{file: o.file, data: data})
+	}
+
+	return files, nil
+}
+
+// renderTemplate renders haproxy config for specified apps with template
+func (c *HaproxyConfigurator) renderTemplate(t *template.Template, apps map[int]HaproxyApp) ([]byte, error) {
+	b := bytes.Buffer{}
+
+	err := t.Execute(&b, haproxyConfigContext{
+		Bind:          c.bind,
+		Apps:          apps,
+		MasterWorker:  c.master,
+		HardStopAfter: fmt.Sprintf("%dms", c.timeout/time.Millisecond),
+		HTTPPort:      c.http,
+		VHosts:        collectVHosts(apps),
+		Certificates:  c.certs,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error executing template: %s", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// applyConfig writes additional outputs, validates config in temporary
+// file, replaces current config with it and reloads haproxy, previous
+// files are restored if anything fails, so files on disk always match
+// the running haproxy, additional outputs are written before validation
+// because haproxy config may reference them
+func (c *HaproxyConfigurator) applyConfig(files []haproxyFile) error {
+	prev := map[string][]byte{}
+
+	restore := func() {
+		for file, data := range prev {
+			restoreFile(file, data)
+		}
+	}
+
+	for _, f := range files[1:] {
+		data, err := ioutil.ReadFile(f.file)
+		if err != nil && !os.IsNotExist(err) {
+			restore()
+			return err
+		}
+
+		prev[f.file] = data
+
+		err = writeFileAtomic(f.file, f.data)
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(c.conf), "."+filepath.Base(c.conf))
+	if err != nil {
+		restore()
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(files[0].data)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
 
-	err = c.checkHaproxyConfig()
 	if err != nil {
+		restore()
+		return err
+	}
+
+	err = c.checkHaproxyConfig(temp.Name())
+	if err != nil {
+		restore()
 		return err
 	}
 
 	log.Println("config validity checked")
 
+	config, err := ioutil.ReadFile(c.conf)
+	if err != nil && !os.IsNotExist(err) {
+		restore()
+		return err
+	}
+
+	err = os.Rename(temp.Name(), c.conf)
+	if err != nil {
+		restore()
+		return err
+	}
+
+	prev[c.conf] = config
+
+	log.Println("config updated")
+
 	err = c.reloadHaproxy()
 	if err != nil {
+		restore()
 		return err
 	}
 
 	log.Println("haproxy reloaded")
 
-	*r = true
 	return nil
 }
 
-// updateConfig writes new config for haproxy
-// if template can be parsed and executed
-func (c *HaproxyConfigurator) updateConfig() error {
-	temp, err := os.Create(c.conf + ".next")
+// restoreFile puts previous contents of file back after failed
+// reload, file is removed if it did not exist before
+func restoreFile(file string, prev []byte) {
+	var err error
+	if prev == nil {
+		err = os.Remove(file)
+	} else {
+		err = writeFileAtomic(file, prev)
+	}
+
 	if err != nil {
-		return err
+		log.Println("error restoring previous "+file+":", err)
+		return
 	}
 
-	defer temp.Close()
+	log.Println("previous " + file + " restored")
+}
 
-	err = c.template.Execute(temp, haproxyConfigContext{
-		Bind: c.bind,
-		Apps: c.apps,
-	})
+// updateRuntime updates servers through runtime api
+// and writes files that match new servers
+func (c *HaproxyConfigurator) updateRuntime(apps map[int]HaproxyApp) error {
+	files, err := c.renderConfig(apps)
+	if err != nil {
+		return err
+	}
 
+	cmds, err := runtimeCommands(c.apps, apps)
 	if err != nil {
 		return err
 	}
 
-	return os.Rename(temp.Name(), c.conf)
+	err = runRuntimeCommands(c.socket, cmds)
+	if err != nil {
+		return err
+	}
+
+	c.apps = apps
+
+	for _, f := range files {
+		err = writeFileAtomic(f.file, f.data)
+		if err != nil {
+			log.Println("error writing "+f.file+" after runtime update:", err)
+		}
+	}
+
+	return nil
 }
 
-// checkHaproxyConfig checks if written haproxy config is valid
-func (c *HaproxyConfigurator) checkHaproxyConfig() error {
-	_, err := exec.Command("haproxy", "-c", "-f", c.conf).CombinedOutput()
-	return err
+// checkHaproxyConfig checks if haproxy config in specified file is valid
+func (c *HaproxyConfigurator) checkHaproxyConfig(file string) error {
+	out, err := exec.Command("haproxy", "-c", "-f", file).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error checking config: %s, output: %s", err, string(out))
+	}
+
+	return nil
 }
 
 // reloadHaproxy gracefully reloads haproxy and starts haproxy if needed
@@ -178,7 +464,9 @@ func (c *HaproxyConfigurator) reloadHaproxy() error {
 		return c.startHaproxy()
 	}
 
-	c.scheduleTermination(pid)
+	if c.master {
+		return c.reloadMaster(pid)
+	}
 
 	cmd := exec.Command("haproxy", "-D", "-f", c.conf, "-p", c.pidfile, "-sf", strconv.Itoa(pid))
 	out, err := cmd.CombinedOutput()
@@ -186,11 +474,19 @@ func (c *HaproxyConfigurator) reloadHaproxy() error {
 		return fmt.Errorf("error reloading conf: %s, output: %s", err, string(out))
 	}
 
+	// previous haproxy is only terminated if the new one has started,
+	// otherwise it is still the only one that serves traffic
+	c.scheduleTermination(pid)
+
 	return nil
 }
 
 // startHaproxy starts haproxy process in the background
 func (c *HaproxyConfigurator) startHaproxy() error {
+	if c.master {
+		return c.startMaster()
+	}
+
 	return exec.Command("haproxy", "-D", "-f", c.conf, "-p", c.pidfile).Run()
 }
 
@@ -207,7 +503,7 @@ func (c *HaproxyConfigurator) scheduleTermination(pid int) {
 				if err == syscall.ESRCH {
 					log.Printf("haproxy with pid %d gracefully exited before we killed it\n", pid)
 				} else {
-					log.Println("error checking that haproxy with pid %d is still running: %s\n", pid, err)
+					log.Printf("error checking that haproxy with pid %d is still running: %s\n", pid, err)
 				}
 
 				break
@@ -238,16 +534,40 @@ func stateToApps(s State) map[int]HaproxyApp {
 				continue
 			}
 
+			options, errs := parseHaproxyOptions(a.Labels)
+
 			app := HaproxyApp{
-				Port:    p,
-				Servers: []HaproxyServer{},
-				Labels:  a.Labels,
+				ID:        a.Name,
+				Port:      p,
+				PortIndex: i,
+				Backend:   fmt.Sprintf("app-%d", p),
+				Servers:   []HaproxyServer{},
+				Labels:    a.Labels,
+				Options:   options,
+				Errors:    errs,
+			}
+
+			if i < len(a.PortNames) {
+				app.PortName = a.PortNames[i]
+			}
+
+			if v, ok := a.Labels[haproxyVHostLabel]; ok && i == 0 {
+				if options.Mode == "http" {
+					vhosts, errs := parseHaproxyVHosts(v, app.Backend)
+					app.VHosts = vhosts
+					app.Errors = append(app.Errors, errs...)
+				} else {
+					app.Errors = append(app.Errors, "virtual hosts are only supported in http mode")
+				}
 			}
 
 			for _, t := range a.Tasks {
 				server := HaproxyServer{
-					Host: t.Host,
-					Port: t.Ports[i],
+					Host:      t.Host,
+					Port:      t.Ports[i],
+					TaskID:    t.ID,
+					StagedAt:  t.StagedAt,
+					StartedAt: t.StartedAt,
 				}
 
 				app.Servers = append(app.Servers, server)
@@ -257,5 +577,7 @@ func stateToApps(s State) map[int]HaproxyApp {
 		}
 	}
 
+	resolveVHostConflicts(r)
+
 	return r
 }
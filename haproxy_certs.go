@@ -0,0 +1,91 @@
+package marathoner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+)
+
+// SetCertificates makes configurator terminate tls for apps with
+// marathoner_haproxy_tls label using certificates from specified
+// directory, haproxy picks certificate by sni. Directory is checked
+// for changes with specified interval and haproxy is reloaded
+// when certificates are added, removed or rotated.
+func (c *HaproxyConfigurator) SetCertificates(dir string, interval time.Duration) {
+	c.mutex.Lock()
+	c.certs = dir
+	c.mutex.Unlock()
+
+	if interval > 0 {
+		go c.watchCertificates(dir, interval)
+	}
+}
+
+// refuseTLSApps removes apps that ask for tls termination if there
+// are no certificates, serving them in plain text is not an option
+func refuseTLSApps(apps map[int]HaproxyApp, certs string) {
+	if certs != "" {
+		return
+	}
+
+	for port, app := range apps {
+		if app.Options.TLS {
+			log.Printf("refusing app %s on port %d: tls is requested, but there are no certificates\n", app.ID, port)
+			delete(apps, port)
+		}
+	}
+}
+
+// watchCertificates reloads haproxy every time certificates change
+func (c *HaproxyConfigurator) watchCertificates(dir string, interval time.Duration) {
+	prev, err := certificatesFingerprint(dir)
+	if err != nil {
+		log.Println("error reading certificates:", err)
+	}
+
+	for {
+		time.Sleep(interval)
+
+		fp, err := certificatesFingerprint(dir)
+		if err != nil {
+			log.Println("error reading certificates:", err)
+			continue
+		}
+
+		if fp == prev {
+			continue
+		}
+
+		log.Println("certificates changed in " + dir + ", reloading haproxy")
+
+		err = c.Refresh()
+		if err != nil {
+			log.Println("error reloading haproxy with new certificates:", err)
+			continue
+		}
+
+		prev = fp
+	}
+}
+
+// certificatesFingerprint returns string that changes every time
+// files in certificates directory are added, removed or modified
+func certificatesFingerprint(dir string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	r := []string{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		r = append(r, fmt.Sprintf("%s:%d:%d", f.Name(), f.Size(), f.ModTime().UnixNano()))
+	}
+
+	return strings.Join(r, "\n"), nil
+}
@@ -0,0 +1,106 @@
+package marathoner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestCertificatesFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marathoner-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	empty, err := certificatesFingerprint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "example.com.pem"), []byte("cert"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := certificatesFingerprint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added == empty {
+		t.Fatal("fingerprint did not change after adding certificate")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "example.com.pem"), []byte("rotated"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := certificatesFingerprint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated == added {
+		t.Fatal("fingerprint did not change after rotating certificate")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("temp"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hidden, err := certificatesFingerprint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hidden != rotated {
+		t.Fatal("fingerprint changed after adding hidden file")
+	}
+}
+
+func TestHaproxyConfiguratorRefusesTLSWithoutCertificates(t *testing.T) {
+	tmpl := template.Must(template.New("").Parse(`{{ range .Apps }}port {{ .Port }}{{ if .Options.TLS }} tls{{ end }}
+{{ end }}`))
+
+	s := State{
+		"/plain": App{
+			Name:   "/plain",
+			Labels: map[string]string{"marathoner_haproxy_enabled": "true"},
+			Ports:  []int{10001},
+		},
+		"/secure": App{
+			Name:   "/secure",
+			Labels: map[string]string{"marathoner_haproxy_enabled": "true", "marathoner_haproxy_tls": "true"},
+			Ports:  []int{10002},
+		},
+	}
+
+	c := NewHaproxyConfigurator(tmpl, "haproxy.cfg", "127.0.0.1", "haproxy.pid", time.Second)
+
+	out, err := c.Render(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "port 10001\n" {
+		t.Fatalf("unexpected config without certificates:\n%s", out)
+	}
+
+	c.SetCertificates("/etc/haproxy/certs", 0)
+
+	out, err = c.Render(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "port 10001\nport 10002 tls\n" {
+		t.Fatalf("unexpected config with certificates:\n%s", out)
+	}
+}
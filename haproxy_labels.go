@@ -0,0 +1,193 @@
+package marathoner
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// haproxyLabelPrefix is a prefix of marathon labels for haproxy options
+const haproxyLabelPrefix = "marathoner_haproxy_"
+
+// haproxyTimeout matches haproxy time values, like 5s or 100ms
+var haproxyTimeout = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+
+// haproxyBalances are balance algorithms without arguments
+var haproxyBalances = map[string]bool{
+	"roundrobin": true,
+	"static-rr":  true,
+	"leastconn":  true,
+	"first":      true,
+	"source":     true,
+	"uri":        true,
+	"random":     true,
+}
+
+// haproxyBalanceArgs match balance algorithms with arguments
+var haproxyBalanceArgs = []*regexp.Regexp{
+	regexp.MustCompile(`^hdr\([A-Za-z0-9-]+\)$`),
+	regexp.MustCompile(`^url_param [A-Za-z0-9_-]+$`),
+	regexp.MustCompile(`^rdp-cookie(\([A-Za-z0-9_-]+\))?$`),
+}
+
+// haproxyHTTPReuses are allowed http-reuse values
+var haproxyHTTPReuses = map[string]bool{
+	"never":      true,
+	"safe":       true,
+	"aggressive": true,
+	"always":     true,
+}
+
+// HaproxyOptions are per-app haproxy settings set with marathon labels:
+//
+//	marathoner_haproxy_mode             tcp (default) or http
+//	marathoner_haproxy_balance          balance algorithm, leastconn by default
+//	marathoner_haproxy_timeout_connect  connect timeout, like 5s
+//	marathoner_haproxy_timeout_server   server timeout, like 50s
+//	marathoner_haproxy_maxconn          maximum number of connections
+//	marathoner_haproxy_httpchk          path for http health checks, like /health
+//	marathoner_haproxy_send_proxy       true to send proxy protocol header to servers
+//	marathoner_haproxy_http_reuse       http-reuse mode, only in http mode
+//	marathoner_haproxy_retries          number of retries on connection failures
+//	marathoner_haproxy_tls              true to terminate tls with listener certificates
+//
+// Empty and zero values mean that defaults from config template apply,
+// except for Retries, where -1 means default.
+type HaproxyOptions struct {
+	Mode           string
+	Balance        string
+	TimeoutConnect string
+	TimeoutServer  string
+	MaxConn        int
+	HTTPCheck      string
+	SendProxy      bool
+	HTTPReuse      string
+	Retries        int
+	TLS            bool
+}
+
+// parseHaproxyOptions parses haproxy options from labels, invalid labels
+// are reported as errors and ignored in favor of default values
+func parseHaproxyOptions(labels map[string]string) (HaproxyOptions, []string) {
+	o := HaproxyOptions{
+		Mode:    "tcp",
+		Balance: "leastconn",
+		Retries: -1,
+	}
+
+	errs := []string{}
+	invalid := func(name, value string) {
+		errs = append(errs, fmt.Sprintf("invalid value %q for label %s%s", value, haproxyLabelPrefix, name))
+	}
+
+	get := func(name string) (string, bool) {
+		v, ok := labels[haproxyLabelPrefix+name]
+		return strings.TrimSpace(v), ok
+	}
+
+	if v, ok := get("mode"); ok {
+		if v == "tcp" || v == "http" {
+			o.Mode = v
+		} else {
+			invalid("mode", v)
+		}
+	}
+
+	if v, ok := get("balance"); ok {
+		if validHaproxyBalance(v) {
+			o.Balance = v
+		} else {
+			invalid("balance", v)
+		}
+	}
+
+	if v, ok := get("timeout_connect"); ok {
+		if haproxyTimeout.MatchString(v) {
+			o.TimeoutConnect = v
+		} else {
+			invalid("timeout_connect", v)
+		}
+	}
+
+	if v, ok := get("timeout_server"); ok {
+		if haproxyTimeout.MatchString(v) {
+			o.TimeoutServer = v
+		} else {
+			invalid("timeout_server", v)
+		}
+	}
+
+	if v, ok := get("maxconn"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			o.MaxConn = n
+		} else {
+			invalid("maxconn", v)
+		}
+	}
+
+	if v, ok := get("httpchk"); ok {
+		if strings.HasPrefix(v, "/") && !strings.ContainsAny(v, " \t\r\n") {
+			o.HTTPCheck = v
+		} else {
+			invalid("httpchk", v)
+		}
+	}
+
+	if v, ok := get("send_proxy"); ok {
+		switch v {
+		case "true", "1":
+			o.SendProxy = true
+		case "false", "0":
+			o.SendProxy = false
+		default:
+			invalid("send_proxy", v)
+		}
+	}
+
+	if v, ok := get("http_reuse"); ok {
+		if haproxyHTTPReuses[v] && o.Mode == "http" {
+			o.HTTPReuse = v
+		} else {
+			invalid("http_reuse", v)
+		}
+	}
+
+	if v, ok := get("retries"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n >= 0 {
+			o.Retries = n
+		} else {
+			invalid("retries", v)
+		}
+	}
+
+	if v, ok := get("tls"); ok {
+		switch v {
+		case "true", "1":
+			o.TLS = true
+		case "false", "0":
+			o.TLS = false
+		default:
+			invalid("tls", v)
+		}
+	}
+
+	return o, errs
+}
+
+// validHaproxyBalance returns true if balance algorithm is known
+func validHaproxyBalance(v string) bool {
+	if haproxyBalances[v] {
+		return true
+	}
+
+	for _, r := range haproxyBalanceArgs {
+		if r.MatchString(v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,94 @@
+package marathoner
+
+import "testing"
+
+func TestParseHaproxyOptions(t *testing.T) {
+	o, errs := parseHaproxyOptions(map[string]string{
+		"marathoner_haproxy_mode":            "http",
+		"marathoner_haproxy_balance":         "roundrobin",
+		"marathoner_haproxy_timeout_connect": "5s",
+		"marathoner_haproxy_maxconn":         "100",
+		"marathoner_haproxy_httpchk":         "/health",
+		"marathoner_haproxy_send_proxy":      "true",
+		"marathoner_haproxy_http_reuse":      "safe",
+		"marathoner_haproxy_retries":         "0",
+		"marathoner_haproxy_tls":             "true",
+	})
+
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := HaproxyOptions{
+		Mode:           "http",
+		Balance:        "roundrobin",
+		TimeoutConnect: "5s",
+		MaxConn:        100,
+		HTTPCheck:      "/health",
+		SendProxy:      true,
+		HTTPReuse:      "safe",
+		Retries:        0,
+		TLS:            true,
+	}
+
+	if o != expected {
+		t.Fatalf("got options %+v, expected %+v", o, expected)
+	}
+}
+
+func TestParseHaproxyOptionsInvalid(t *testing.T) {
+	o, errs := parseHaproxyOptions(map[string]string{
+		"marathoner_haproxy_mode":           "udp",
+		"marathoner_haproxy_balance":        "leastconn\n  bind :80",
+		"marathoner_haproxy_timeout_server": "forever",
+		"marathoner_haproxy_maxconn":        "-1",
+		"marathoner_haproxy_http_reuse":     "safe",
+		"marathoner_haproxy_retries":        "many",
+	})
+
+	if len(errs) != 6 {
+		t.Fatalf("got %d errors, expected 6: %v", len(errs), errs)
+	}
+
+	expected := HaproxyOptions{
+		Mode:    "tcp",
+		Balance: "leastconn",
+		Retries: -1,
+	}
+
+	if o != expected {
+		t.Fatalf("got options %+v, expected defaults %+v", o, expected)
+	}
+
+	for _, balance := range []string{"hdr(", "hdr(x) garbage", "rdp-cookieXYZ", "url_param a b c"} {
+		o, errs := parseHaproxyOptions(map[string]string{"marathoner_haproxy_balance": balance})
+		if len(errs) != 1 || o.Balance != "leastconn" {
+			t.Fatalf("balance %q is accepted as %q with errors %v", balance, o.Balance, errs)
+		}
+	}
+}
+
+func TestValidHaproxyBalance(t *testing.T) {
+	cases := map[string]bool{
+		"roundrobin":             true,
+		"hdr(Host)":              true,
+		"hdr(X-Forwarded-For)":   true,
+		"url_param session_id":   true,
+		"rdp-cookie":             true,
+		"rdp-cookie(mstshash)":   true,
+		"hdr(":                   false,
+		"hdr(x) garbage":         false,
+		"rdp-cookieXYZ":          false,
+		"url_param a b c":        false,
+		"url_param ":             false,
+		"hdr(x)\n  bind :80":     false,
+		"leastconn\n  bind :80":  false,
+		"rdp-cookie(a)\nbind :1": false,
+	}
+
+	for v, valid := range cases {
+		if validHaproxyBalance(v) != valid {
+			t.Errorf("balance %q valid: %v, expected %v", v, !valid, valid)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package marathoner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SetMasterWorker makes configurator run haproxy in master-worker mode
+// as a child process and reload it with SIGUSR2, previous workers are
+// stopped by haproxy itself after timeout, template should set
+// hard-stop-after to HardStopAfter. If master cli socket is specified,
+// haproxy is reloaded with reload command of master cli, so failed
+// reloads are detected, and the number of old workers is logged
+// after every reload.
+func (c *HaproxyConfigurator) SetMasterWorker(cli string) {
+	c.mutex.Lock()
+	c.master = true
+	c.cli = cli
+	c.mutex.Unlock()
+}
+
+// masterStartTimeout is how long haproxy master has to write pidfile
+// and to open master cli before its start is considered failed
+const masterStartTimeout = time.Second * 10
+
+// startMaster starts haproxy master process as a child process
+// and waits for it to come up, master that exits early or does
+// not come up in time is reported as an error
+func (c *HaproxyConfigurator) startMaster() error {
+	args := []string{"-W", "-f", c.conf, "-p", c.pidfile}
+	if c.cli != "" {
+		args = append(args, "-S", c.cli)
+	}
+
+	cmd := exec.Command("haproxy", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	pid := cmd.Process.Pid
+
+	log.Printf("started haproxy master with pid %d\n", pid)
+
+	exited := make(chan error, 1)
+
+	go func() {
+		err := cmd.Wait()
+		log.Printf("haproxy master with pid %d exited: %v\n", pid, err)
+		exited <- err
+	}()
+
+	t := time.NewTicker(time.Millisecond * 100)
+	defer t.Stop()
+
+	deadline := time.After(masterStartTimeout)
+
+	for !c.masterReady(pid) {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("haproxy master with pid %d exited on start: %v", pid, err)
+		case <-deadline:
+			// next reload starts a new master, this one should not linger
+			cmd.Process.Kill()
+			return fmt.Errorf("haproxy master with pid %d did not come up in %s", pid, masterStartTimeout)
+		case <-t.C:
+		}
+	}
+
+	log.Printf("haproxy master with pid %d is up\n", pid)
+
+	return nil
+}
+
+// masterReady returns true if haproxy master with specified pid
+// wrote it to pidfile and accepts connections on master cli
+func (c *HaproxyConfigurator) masterReady(pid int) bool {
+	p, err := ioutil.ReadFile(c.pidfile)
+	if err != nil || strings.TrimSpace(string(p)) != strconv.Itoa(pid) {
+		return false
+	}
+
+	if c.cli == "" {
+		return true
+	}
+
+	conn, err := net.Dial("unix", c.cli)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
+
+// reloadMaster asks haproxy master to reload configuration, through
+// master cli if it is configured to get the result of reload
+func (c *HaproxyConfigurator) reloadMaster(pid int) error {
+	if c.cli != "" {
+		log.Printf("asking haproxy master with pid %d to reload through master cli\n", pid)
+
+		err := reloadMasterCLI(c.cli)
+		if err != nil {
+			return err
+		}
+
+		go c.logOldWorkers()
+
+		return nil
+	}
+
+	log.Printf("sending SIGUSR2 to haproxy master with pid %d\n", pid)
+
+	return syscall.Kill(pid, syscall.SIGUSR2)
+}
+
+// logOldWorkers logs the number of old workers that are still running
+func (c *HaproxyConfigurator) logOldWorkers() {
+	// give master some time to finish reload
+	time.Sleep(time.Second)
+
+	n, err := countOldWorkers(c.cli)
+	if err != nil {
+		log.Println("error getting haproxy workers from master cli:", err)
+		return
+	}
+
+	log.Printf("haproxy has %d old workers running\n", n)
+}
+
+// masterCommand runs command on haproxy master cli
+// on specified socket and returns its output
+func masterCommand(socket, command string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("unix", socket, time.Second*5)
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = conn.Write([]byte(command + "\n"))
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ioutil.ReadAll(conn)
+
+	return string(out), err
+}
+
+// reloadMasterCLI reloads haproxy with reload command of master cli
+// on specified socket and returns error if reload failed
+func reloadMasterCLI(socket string) error {
+	out, err := masterCommand(socket, "reload", time.Minute)
+	if err != nil {
+		return err
+	}
+
+	return parseReloadResult(out)
+}
+
+// parseReloadResult parses output of reload command of master cli,
+// haproxy before 2.7 closes connection without reporting the result,
+// reload is assumed to be successful then
+func parseReloadResult(out string) error {
+	lines := strings.SplitN(strings.TrimSpace(out), "\n", 2)
+
+	switch strings.TrimSpace(lines[0]) {
+	case "Success=1":
+		return nil
+	case "Success=0":
+		return fmt.Errorf("haproxy master failed to reload, output: %s", out)
+	case "":
+		log.Println("haproxy master did not report result of reload")
+		return nil
+	}
+
+	return fmt.Errorf("unexpected response to reload from haproxy master: %s", out)
+}
+
+// countOldWorkers counts old workers from "show proc"
+// output of haproxy master cli on specified socket
+func countOldWorkers(socket string) (int, error) {
+	out, err := masterCommand(socket, "show proc", time.Second*10)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseOldWorkers(out), nil
+}
+
+// parseOldWorkers counts old workers in "show proc" output
+func parseOldWorkers(out string) int {
+	n := 0
+	old := false
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "#") {
+			old = strings.Contains(line, "old workers")
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if old && len(fields) > 1 && fields[1] == "worker" {
+			n++
+		}
+	}
+
+	return n
+}
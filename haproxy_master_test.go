@@ -0,0 +1,161 @@
+package marathoner
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseOldWorkers(t *testing.T) {
+	cases := map[string]struct {
+		out      string
+		expected int
+	}{
+		"empty": {
+			out:      "",
+			expected: 0,
+		},
+		"no old workers": {
+			out: "#<PID>          <type>          <reloads>       <uptime>        <version>\n" +
+				"1162            master          0 [failed: 0]   0d00h02m07s     2.5.0\n" +
+				"# workers\n" +
+				"1271            worker          0               0d00h02m07s     2.5.0\n" +
+				"# programs\n",
+			expected: 0,
+		},
+		"old workers": {
+			out: "#<PID>          <type>          <reloads>       <uptime>        <version>\n" +
+				"1162            master          5 [failed: 0]   0d00h02m07s     2.5.0\n" +
+				"# workers\n" +
+				"1271            worker          0               0d00h00m00s     2.5.0\n" +
+				"# old workers\n" +
+				"1233            worker          3               0d00h00m43s     2.5.0\n" +
+				"1240            worker          2               0d00h00m20s     2.5.0\n" +
+				"# programs\n" +
+				"1250            program         0               0d00h02m07s     -\n",
+			expected: 2,
+		},
+		"old format": {
+			out: "#<PID>          <type>          <relative PID>  <reloads>       <uptime>\n" +
+				"1162            master          0               2               0d00h01m15s\n" +
+				"# workers\n" +
+				"1271            worker          1               0               0d00h00m01s\n" +
+				"# old workers\n" +
+				"1233            worker          [was: 1]        1               0d00h00m30s\n",
+			expected: 1,
+		},
+	}
+
+	for name, c := range cases {
+		if n := parseOldWorkers(c.out); n != c.expected {
+			t.Errorf("%s: got %d old workers, expected %d", name, n, c.expected)
+		}
+	}
+}
+
+func TestParseReloadResult(t *testing.T) {
+	cases := map[string]bool{
+		"Success=1\n--\n[NOTICE]   (1162) : Reloading HAProxy\n": true,
+		"": true,
+		"Success=0\n--\n[ALERT]    (1162) : config : parsing [haproxy.cfg]": false,
+		"Unknown command: 'reload'\n":                                       false,
+	}
+
+	for out, ok := range cases {
+		err := parseReloadResult(out)
+		if (err == nil) != ok {
+			t.Errorf("got error %v for output %q", err, out)
+		}
+	}
+}
+
+func TestReloadMasterCLI(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "master.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	responses := []string{"Success=1\n--\n", "Success=0\n--\n[ALERT] config is invalid\n"}
+
+	go func() {
+		for _, r := range responses {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			if line == "reload\n" {
+				conn.Write([]byte(r))
+			}
+
+			conn.Close()
+		}
+	}()
+
+	if err := reloadMasterCLI(socket); err != nil {
+		t.Fatalf("successful reload returned error: %s", err)
+	}
+
+	if err := reloadMasterCLI(socket); err == nil {
+		t.Fatal("failed reload returned no error")
+	}
+}
+
+// masterTestConfigurator puts haproxy script into PATH and returns
+// configurator that runs it in master-worker mode
+func masterTestConfigurator(t *testing.T, script string) *HaproxyConfigurator {
+	dir := t.TempDir()
+
+	err := ioutil.WriteFile(filepath.Join(dir, "haproxy"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	c := NewHaproxyConfigurator(nil, filepath.Join(dir, "haproxy.cfg"), "127.0.0.1", filepath.Join(dir, "haproxy.pid"), time.Second)
+	c.SetMasterWorker("")
+
+	return c
+}
+
+func TestStartMasterFailsOnExit(t *testing.T) {
+	c := masterTestConfigurator(t, "#!/bin/sh\necho cannot bind socket; exit 1\n")
+
+	if err := c.startMaster(); err == nil {
+		t.Fatal("haproxy master that exited on start is reported as started")
+	}
+}
+
+func TestStartMasterWaitsForPidfile(t *testing.T) {
+	// master writes pidfile after a while, like haproxy after parsing config
+	c := masterTestConfigurator(t, "#!/bin/sh\nsleep 0.3\necho $$ > \"$5\"\nexec sleep 10\n")
+
+	if err := c.startMaster(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ioutil.ReadFile(c.pidfile)
+	if err != nil {
+		t.Fatalf("haproxy master is reported as started before writing pidfile: %s", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(p)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syscall.Kill(pid, syscall.SIGKILL)
+}
@@ -0,0 +1,51 @@
+package marathoner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"text/template"
+)
+
+// haproxyOutput is an additional file rendered from the same state
+type haproxyOutput struct {
+	template *template.Template
+	file     string
+}
+
+// haproxyFile is rendered contents of a file
+type haproxyFile struct {
+	file string
+	data []byte
+}
+
+// AddOutput makes configurator render additional file from the same
+// state with specified template, like maps or env files for other tools.
+// Additional files are written along with haproxy config, before it is
+// validated, and haproxy is reloaded once for all of them.
+func (c *HaproxyConfigurator) AddOutput(t *template.Template, file string) {
+	c.mutex.Lock()
+	c.outputs = append(c.outputs, haproxyOutput{template: t, file: file})
+	c.mutex.Unlock()
+}
+
+// filesChanged returns true if any of rendered files
+// differs from the file on disk with the same name
+func filesChanged(files []haproxyFile) (bool, error) {
+	for _, f := range files {
+		current, err := ioutil.ReadFile(f.file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		if !bytes.Equal(current, f.data) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,363 @@
+package marathoner
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func slotTestApps(servers ...HaproxyServer) map[int]HaproxyApp {
+	return map[int]HaproxyApp{
+		1234: {
+			Port:    1234,
+			Backend: "app-1234",
+			Servers: servers,
+		},
+	}
+}
+
+func TestAssignSlotsKeepsServers(t *testing.T) {
+	a := HaproxyServer{Host: "10.0.0.1", Port: 31000}
+	b := HaproxyServer{Host: "10.0.0.2", Port: 31000}
+	c := HaproxyServer{Host: "10.0.0.3", Port: 31000}
+
+	prev := slotTestApps(a, b)
+	assignSlots(nil, prev, 4)
+
+	if len(prev[1234].Slots) != 4 {
+		t.Fatalf("got %d slots, expected 4", len(prev[1234].Slots))
+	}
+
+	next := slotTestApps(c, b)
+	assignSlots(prev, next, 4)
+
+	expected := []HaproxySlot{
+		{Name: "srv1", Host: "10.0.0.3", Port: 31000, Enabled: true},
+		{Name: "srv2", Host: "10.0.0.2", Port: 31000, Enabled: true},
+		{Name: "srv3"},
+		{Name: "srv4"},
+	}
+
+	if !reflect.DeepEqual(next[1234].Slots, expected) {
+		t.Fatalf("got slots %v, expected %v", next[1234].Slots, expected)
+	}
+
+	if !sameStructure(prev, next) {
+		t.Fatal("apps with the same number of slots have different structure")
+	}
+
+	cmds, err := runtimeCommands(prev, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCmds := []string{
+		"set server app-1234/srv1 addr 10.0.0.3 port 31000",
+		"set server app-1234/srv1 state ready",
+	}
+
+	if !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Fatalf("got commands %v, expected %v", cmds, expectedCmds)
+	}
+}
+
+func TestAssignSlotsGrows(t *testing.T) {
+	prev := slotTestApps(HaproxyServer{Host: "10.0.0.1", Port: 31000})
+	assignSlots(nil, prev, 1)
+
+	next := slotTestApps(
+		HaproxyServer{Host: "10.0.0.1", Port: 31000},
+		HaproxyServer{Host: "10.0.0.2", Port: 31000},
+		HaproxyServer{Host: "10.0.0.3", Port: 31000},
+	)
+
+	assignSlots(prev, next, 1)
+
+	if len(next[1234].Slots) != 4 {
+		t.Fatalf("got %d slots, expected 4", len(next[1234].Slots))
+	}
+
+	if sameStructure(prev, next) {
+		t.Fatal("apps with different number of slots have the same structure")
+	}
+}
+
+// fakeHaproxy puts haproxy script into PATH that rejects configs
+// containing word "invalid" and does nothing otherwise
+func fakeHaproxy(t *testing.T) {
+	dir := t.TempDir()
+
+	script := "#!/bin/sh\nif [ \"$1\" = \"-c\" ] && grep -q invalid \"$3\"; then echo invalid config; exit 1; fi\n"
+
+	err := ioutil.WriteFile(filepath.Join(dir, "haproxy"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestHaproxyConfiguratorKeepsConfigOnFailure(t *testing.T) {
+	fakeHaproxy(t)
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "haproxy.cfg")
+
+	tmpl := template.Must(template.New("config").Parse(`{{ range .Apps }}{{ index .Labels "content" }}{{ end }}`))
+
+	c := NewHaproxyConfigurator(tmpl, conf, "127.0.0.1", filepath.Join(dir, "haproxy.pid"), time.Second)
+
+	state := func(content string) State {
+		return State{
+			"/app": App{
+				Name:   "/app",
+				Labels: map[string]string{"marathoner_haproxy_enabled": "true", "content": content},
+				Ports:  []int{1234},
+			},
+		}
+	}
+
+	r := false
+	if err := c.Update(state("valid"), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Update(state("invalid"), &r); err == nil {
+		t.Fatal("invalid config is applied")
+	}
+
+	b, err := ioutil.ReadFile(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "valid" {
+		t.Fatalf("config is %q after failed update, expected %q", string(b), "valid")
+	}
+
+	if c.apps[1234].Labels["content"] != "valid" {
+		t.Fatal("configurator apps do not match applied config")
+	}
+}
+
+func TestHaproxyConfiguratorSetTemplate(t *testing.T) {
+	fakeHaproxy(t)
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "haproxy.cfg")
+
+	parse := func(s string) *template.Template {
+		return template.Must(template.New("config").Parse(s))
+	}
+
+	c := NewHaproxyConfigurator(parse(`{{ range .Apps }}port {{ .Port }}{{ end }}`), conf, "127.0.0.1", filepath.Join(dir, "haproxy.pid"), time.Second)
+
+	r := false
+	err := c.Update(State{
+		"/app": App{
+			Name:   "/app",
+			Labels: map[string]string{"marathoner_haproxy_enabled": "true"},
+			Ports:  []int{1234},
+		},
+	}, &r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SetTemplate(parse(`{{ range .Apps }}{{ .Missing }}{{ end }}`)); err == nil {
+		t.Fatal("template that fails to execute is accepted")
+	}
+
+	if err := c.SetTemplate(parse(`{{ range .Apps }}invalid {{ .Port }}{{ end }}`)); err == nil {
+		t.Fatal("template that renders invalid config is accepted")
+	}
+
+	if err := c.SetTemplate(parse(`{{ range .Apps }}server {{ .Port }}{{ end }}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "server 1234" {
+		t.Fatalf("config is %q after template change, expected %q", string(b), "server 1234")
+	}
+}
+
+func TestHaproxyConfiguratorOutputs(t *testing.T) {
+	fakeHaproxy(t)
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "haproxy.cfg")
+	env := filepath.Join(dir, "apps.env")
+
+	tmpl := template.Must(template.New("config").Parse(`{{ range .Apps }}{{ index .Labels "content" }}{{ end }}`))
+	out := template.Must(template.New("env").Parse(`{{ range .Apps }}PORT={{ .Port }}{{ end }}`))
+
+	c := NewHaproxyConfigurator(tmpl, conf, "127.0.0.1", filepath.Join(dir, "haproxy.pid"), time.Second)
+	c.AddOutput(out, env)
+
+	state := func(content string, port int) State {
+		return State{
+			"/app": App{
+				Name:   "/app",
+				Labels: map[string]string{"marathoner_haproxy_enabled": "true", "content": content},
+				Ports:  []int{port},
+			},
+		}
+	}
+
+	r := false
+	if err := c.Update(state("valid", 1234), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Update(state("invalid", 1235), &r); err == nil {
+		t.Fatal("invalid config is applied")
+	}
+
+	b, err := ioutil.ReadFile(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "PORT=1234" {
+		t.Fatalf("output is %q after failed update, expected %q", string(b), "PORT=1234")
+	}
+}
+
+func TestHaproxyConfiguratorKeepsHaproxyOnFailedReload(t *testing.T) {
+	dir := t.TempDir()
+
+	// haproxy accepts any config, but fails to start new process
+	script := "#!/bin/sh\nif [ \"$1\" = \"-c\" ]; then exit 0; fi\necho cannot bind socket; exit 1\n"
+
+	err := ioutil.WriteFile(filepath.Join(dir, "haproxy"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	running := exec.Command("sleep", "10")
+	if err := running.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer running.Process.Kill()
+
+	exited := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(exited)
+	}()
+
+	pidfile := filepath.Join(dir, "haproxy.pid")
+	if err := ioutil.WriteFile(pidfile, []byte(strconv.Itoa(running.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := template.Must(template.New("config").Parse(`{{ range .Apps }}{{ .Port }}{{ end }}`))
+	c := NewHaproxyConfigurator(tmpl, filepath.Join(dir, "haproxy.cfg"), "127.0.0.1", pidfile, time.Millisecond)
+
+	r := false
+	err = c.Update(State{
+		"/app": App{
+			Name:   "/app",
+			Labels: map[string]string{"marathoner_haproxy_enabled": "true"},
+			Ports:  []int{1234},
+		},
+	}, &r)
+
+	if err == nil {
+		t.Fatal("failed reload returned no error")
+	}
+
+	select {
+	case <-exited:
+		t.Fatal("running haproxy is terminated after failed reload")
+	case <-time.After(time.Second * 2):
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func TestHaproxyConfiguratorRender(t *testing.T) {
+	tmpl, err := template.New("config").Funcs(TemplateFuncs()).ParseFiles("containers/listener/haproxy.cfg.template")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewHaproxyConfigurator(tmpl.Lookup("haproxy.cfg.template"), "", "127.0.0.1", "", time.Minute)
+	c.SetHTTPPort(80)
+	c.SetCertificates("/etc/haproxy/certs", 0)
+
+	config, err := c.Render(State{
+		"/web": App{
+			Name: "/web",
+			Labels: map[string]string{
+				"marathoner_haproxy_enabled": "true",
+				"marathoner_haproxy_mode":    "http",
+				"marathoner_haproxy_balance": "roundrobin",
+				"marathoner_haproxy_httpchk": "/health",
+				"marathoner_haproxy_vhost":   "example.com,example.com/api",
+				"marathoner_haproxy_tls":     "true",
+			},
+			Ports: []int{10000},
+			Tasks: []Task{
+				{ID: "web.1", Host: "10.0.0.1", Ports: []int{31000}},
+				{ID: "web.2", Host: "10.0.0.2", Ports: []int{31001}},
+			},
+		},
+		"/db": App{
+			Name: "/db",
+			Labels: map[string]string{
+				"marathoner_haproxy_enabled":    "true",
+				"marathoner_haproxy_maxconn":    "100",
+				"marathoner_haproxy_send_proxy": "true",
+			},
+			Ports: []int{10001},
+			Tasks: []Task{
+				{ID: "db.1", Host: "10.0.0.3", Ports: []int{31002}},
+			},
+		},
+		"/hidden": App{
+			Name:  "/hidden",
+			Ports: []int{10002},
+			Tasks: []Task{
+				{ID: "hidden.1", Host: "10.0.0.4", Ports: []int{31003}},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "haproxy.cfg.golden")
+
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, config, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := UnifiedDiff(string(expected), string(config), golden, "rendered"); diff != "" {
+		t.Fatalf("rendered config differs from golden file, run tests with -update to update it:\n%s", diff)
+	}
+}
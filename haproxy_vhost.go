@@ -0,0 +1,124 @@
+package marathoner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// haproxyVHostLabel is a label with comma separated list of virtual hosts
+// of http app, every virtual host can have optional path prefix
+const haproxyVHostLabel = haproxyLabelPrefix + "vhost"
+
+// haproxyHost matches valid virtual host names, wildcards are not
+// supported, because host header is matched as an exact string
+var haproxyHost = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+// HaproxyVHost routes requests with specific host header and optional
+// path prefix to backend of http app, path prefix has no trailing slash
+// and matches either the path itself or anything below it, so /api
+// matches /api and /api/users, but not /apiary
+type HaproxyVHost struct {
+	Host    string
+	Path    string
+	Backend string
+}
+
+// parseHaproxyVHosts parses virtual hosts of app with specified backend,
+// invalid virtual hosts are reported as errors and ignored
+func parseHaproxyVHosts(label, backend string) ([]HaproxyVHost, []string) {
+	r := []HaproxyVHost{}
+	errs := []string{}
+
+	for _, v := range strings.Split(label, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		vhost := HaproxyVHost{Host: v, Backend: backend}
+		if i := strings.Index(v, "/"); i >= 0 {
+			vhost.Host, vhost.Path = v[:i], v[i:]
+		}
+
+		vhost.Host = strings.ToLower(vhost.Host)
+		vhost.Path = strings.TrimRight(vhost.Path, "/")
+
+		if !haproxyHost.MatchString(vhost.Host) || strings.ContainsAny(vhost.Path, " \t\r\n") {
+			errs = append(errs, fmt.Sprintf("invalid virtual host %q in label %s", v, haproxyVHostLabel))
+			continue
+		}
+
+		r = append(r, vhost)
+	}
+
+	return r, errs
+}
+
+// resolveVHostConflicts removes virtual hosts claimed by several apps
+// from every app but the one with the lowest port and reports them
+func resolveVHostConflicts(apps map[int]HaproxyApp) {
+	ports := []int{}
+	for port := range apps {
+		ports = append(ports, port)
+	}
+
+	sort.Ints(ports)
+
+	claimed := map[string]int{}
+	for _, port := range ports {
+		app := apps[port]
+
+		vhosts := []HaproxyVHost{}
+		for _, v := range app.VHosts {
+			key := v.Host + v.Path
+			if owner, ok := claimed[key]; ok && owner != port {
+				app.Errors = append(app.Errors, fmt.Sprintf("virtual host %s is already claimed by app on port %d", key, owner))
+				continue
+			}
+
+			claimed[key] = port
+			vhosts = append(vhosts, v)
+		}
+
+		app.VHosts = vhosts
+		apps[port] = app
+	}
+}
+
+// haproxyVHosts is a slice of virtual hosts sorted by host
+// and then by path, longer paths go first to match first
+type haproxyVHosts []HaproxyVHost
+
+func (h haproxyVHosts) Len() int {
+	return len(h)
+}
+
+func (h haproxyVHosts) Less(i, j int) bool {
+	if h[i].Host != h[j].Host {
+		return h[i].Host < h[j].Host
+	}
+
+	if len(h[i].Path) != len(h[j].Path) {
+		return len(h[i].Path) > len(h[j].Path)
+	}
+
+	return h[i].Path < h[j].Path
+}
+
+func (h haproxyVHosts) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// collectVHosts returns sorted virtual hosts of every app
+func collectVHosts(apps map[int]HaproxyApp) []HaproxyVHost {
+	r := haproxyVHosts{}
+	for _, app := range apps {
+		r = append(r, app.VHosts...)
+	}
+
+	sort.Sort(r)
+
+	return r
+}
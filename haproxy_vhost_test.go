@@ -0,0 +1,99 @@
+package marathoner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHaproxyVHosts(t *testing.T) {
+	vhosts, errs := parseHaproxyVHosts("Example.com, example.com/api/,bad host,*.example.org,example.org/", "app-1")
+
+	expected := []HaproxyVHost{
+		{Host: "example.com", Backend: "app-1"},
+		{Host: "example.com", Path: "/api", Backend: "app-1"},
+		{Host: "example.org", Backend: "app-1"},
+	}
+
+	if !reflect.DeepEqual(vhosts, expected) {
+		t.Fatalf("got virtual hosts %+v, expected %+v", vhosts, expected)
+	}
+
+	if len(errs) != 2 {
+		t.Fatalf("expected two errors, got %v", errs)
+	}
+}
+
+func TestStateToAppsVHostConflicts(t *testing.T) {
+	labels := map[string]string{
+		"marathoner_haproxy_enabled": "true",
+		"marathoner_haproxy_mode":    "http",
+		"marathoner_haproxy_vhost":   "example.com",
+	}
+
+	apps := stateToApps(State{
+		"/b": App{Name: "/b", Ports: []int{2}, Labels: labels},
+		"/a": App{Name: "/a", Ports: []int{1}, Labels: labels},
+		"/c": App{Name: "/c", Ports: []int{3}, Labels: map[string]string{
+			"marathoner_haproxy_enabled": "true",
+			"marathoner_haproxy_vhost":   "example.org",
+		}},
+	})
+
+	if len(apps[1].VHosts) != 1 || len(apps[1].Errors) != 0 {
+		t.Fatalf("expected app on port 1 to own virtual host, got %+v", apps[1])
+	}
+
+	if len(apps[2].VHosts) != 0 || len(apps[2].Errors) != 1 {
+		t.Fatalf("expected conflict for app on port 2, got %+v", apps[2])
+	}
+
+	if len(apps[3].VHosts) != 0 || len(apps[3].Errors) != 1 {
+		t.Fatalf("expected tcp app on port 3 to have no virtual hosts, got %+v", apps[3])
+	}
+
+	vhosts := collectVHosts(apps)
+	if len(vhosts) != 1 || vhosts[0].Backend != "app-1" {
+		t.Fatalf("unexpected virtual hosts: %+v", vhosts)
+	}
+}
+
+func TestCollectVHostsOrder(t *testing.T) {
+	vhosts := collectVHosts(map[int]HaproxyApp{
+		1: {VHosts: []HaproxyVHost{{Host: "a.com", Backend: "app-1"}}},
+		2: {VHosts: []HaproxyVHost{{Host: "a.com", Path: "/api", Backend: "app-2"}}},
+		3: {VHosts: []HaproxyVHost{{Host: "0.com", Backend: "app-3"}}},
+	})
+
+	backends := []string{}
+	for _, v := range vhosts {
+		backends = append(backends, v.Backend)
+	}
+
+	if !reflect.DeepEqual(backends, []string{"app-3", "app-2", "app-1"}) {
+		t.Fatalf("unexpected order of virtual hosts: %v", backends)
+	}
+}
+
+func TestStateToAppsVHostPathConflicts(t *testing.T) {
+	labels := func(vhost string) map[string]string {
+		return map[string]string{
+			"marathoner_haproxy_enabled": "true",
+			"marathoner_haproxy_mode":    "http",
+			"marathoner_haproxy_vhost":   vhost,
+		}
+	}
+
+	apps := stateToApps(State{
+		"/a": App{Name: "/a", Ports: []int{1}, Labels: labels("example.com/api")},
+		"/b": App{Name: "/b", Ports: []int{2}, Labels: labels("example.com/api/")},
+		"/c": App{Name: "/c", Ports: []int{3}, Labels: labels("example.com/apiary")},
+	})
+
+	if len(apps[2].VHosts) != 0 || len(apps[2].Errors) != 1 {
+		t.Fatalf("expected conflict for the same path with trailing slash, got %+v", apps[2])
+	}
+
+	if len(apps[3].VHosts) != 1 || len(apps[3].Errors) != 0 {
+		t.Fatalf("expected no conflict for another path, got %+v", apps[3])
+	}
+}
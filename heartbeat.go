@@ -0,0 +1,58 @@
+package marathoner
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// deadlineConn is a connection that fails reads if no data
+// is received within specified timeout, which means that
+// peer is dead if it is supposed to send heartbeats
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read extends read deadline and reads from underlying connection
+func (c deadlineConn) Read(b []byte) (int, error) {
+	err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+// PingArgs is sent by updater to listener with every heartbeat
+type PingArgs struct {
+	// Time is the time when state applied by listener was received
+	// from marathon, served state can be newer, but it has the same
+	// apps that listener is interested in
+	Time time.Time
+	// Confirmed is the last time when marathon returned served state
+	Confirmed time.Time
+}
+
+// heartbeat pings client with specified interval and closes it
+// if it fails to respond within timeout, resulting error is sent
+// to dead channel, heartbeats are stopped when stop is closed
+func heartbeat(c *client, interval, timeout time.Duration, args func() PingArgs, dead chan<- error, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		err := c.ping(args(), timeout)
+		if err != nil {
+			c.Close()
+			dead <- fmt.Errorf("heartbeat failed for %s: %s", c.name, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package marathoner
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ListenForHTTP starts serving state over http on specified location.
+// The following endpoints are available:
+//
+//	/v1/state   current state update as json object
+//	/v1/stream  current state update followed by every change,
+//	            one json object per line
+//	/v1/clients connected rpc clients with their descriptions
+//	/v1/reports apply results for the latest generations of state
+//	/v1/guard   removal guard status, 503 if state is held back
+//
+// State endpoints /v1/state and /v1/stream accept optional selector
+// query argument to receive only matching apps, other endpoints ignore it.
+// If token is set, it must be provided in Authorization header
+// of every endpoint as "Bearer <token>".
+func (u *Updater) ListenForHTTP(listen string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/state", u.authenticated(u.serveState))
+	mux.HandleFunc("/v1/stream", u.authenticated(u.serveStream))
+	mux.HandleFunc("/v1/clients", u.authenticated(u.serveClients))
+	mux.HandleFunc("/v1/reports", u.authenticated(u.serveReports))
+	mux.HandleFunc("/v1/guard", u.authenticated(u.serveGuard))
+
+	s := &http.Server{
+		Addr:      listen,
+		Handler:   mux,
+		TLSConfig: u.tls,
+	}
+
+	if u.tls != nil {
+		return s.ListenAndServeTLS("", "")
+	}
+
+	return s.ListenAndServe()
+}
+
+// authenticated wraps handler to check token if it is set
+func (u *Updater) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if u.token != "" {
+			a := r.Header.Get("Authorization")
+			if !strings.HasPrefix(a, "Bearer ") || !tokenEqual(strings.TrimPrefix(a, "Bearer "), u.token) {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// serveState writes current state update
+func (u *Updater) serveState(w http.ResponseWriter, r *http.Request) {
+	s, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u.mutex.Lock()
+	su := u.serve
+	u.mutex.Unlock()
+
+	if su == nil {
+		http.Error(w, "state is not known yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	update := *su
+	update.State = s.Filter(update.State)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(update)
+}
+
+// clientStatus describes connected rpc client
+type clientStatus struct {
+	Address      string
+	Hostname     string
+	Version      string
+	Configurator string
+	Labels       map[string]string
+	Selector     string
+	Generation   uint64
+	Error        string
+}
+
+// clientStatuses is a slice of clientStatus sorted by address
+type clientStatuses []clientStatus
+
+func (c clientStatuses) Len() int {
+	return len(c)
+}
+
+func (c clientStatuses) Less(i, j int) bool {
+	return c[i].Address < c[j].Address
+}
+
+func (c clientStatuses) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+// serveClients writes list of connected rpc clients
+func (u *Updater) serveClients(w http.ResponseWriter, r *http.Request) {
+	u.mutex.Lock()
+	clients := clientStatuses{}
+	for _, c := range u.peers {
+		clients = append(clients, clientStatus{
+			Address:      c.addr,
+			Hostname:     c.info.Hostname,
+			Version:      c.info.Version,
+			Configurator: c.info.Configurator,
+			Labels:       c.info.Labels,
+			Selector:     c.info.Selector,
+			Generation:   c.result.Generation,
+			Error:        c.result.Error,
+		})
+	}
+	u.mutex.Unlock()
+
+	sort.Sort(clients)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+// serveReports writes apply reports for the latest generations
+func (u *Updater) serveReports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u.Reports())
+}
+
+// guardStatus describes removal guard of updater
+type guardStatus struct {
+	Tripped bool
+	Pending int
+	Polls   int
+}
+
+// serveGuard writes removal guard status, status code is 503
+// if guard is holding back a state, so it can be used for alerts
+func (u *Updater) serveGuard(w http.ResponseWriter, r *http.Request) {
+	status := guardStatus{Tripped: u.GuardTripped()}
+
+	u.mutex.Lock()
+	if u.guard != nil {
+		status.Pending = u.guard.pending
+		status.Polls = u.guard.polls
+	}
+	u.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if status.Tripped {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
+
+// serveStream writes current state update and then every change
+// of state until client disconnects
+func (u *Updater) serveStream(w http.ResponseWriter, r *http.Request) {
+	s, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	name := "http:" + r.RemoteAddr
+
+	su, ch, ok := u.subscribe(name)
+	if !ok {
+		http.Error(w, "state is not known yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	defer u.unsubscribe(name)
+
+	log.Println("http client " + r.RemoteAddr + " subscribed to stream")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	e := json.NewEncoder(w)
+
+	var last *StateUpdate
+	for {
+		su.State = s.Filter(su.State)
+
+		if last == nil || last.Stale != su.Stale || !reflect.DeepEqual(last.State, su.State) {
+			err := e.Encode(su)
+			if err != nil {
+				log.Println("error writing to http client "+r.RemoteAddr+":", err)
+				return
+			}
+
+			f.Flush()
+
+			sent := su
+			last = &sent
+		}
+
+		select {
+		case su, ok = <-ch:
+			if !ok {
+				return
+			}
+		case <-r.Context().Done():
+			log.Println("http client " + r.RemoteAddr + " disconnected from stream")
+			return
+		case <-u.done:
+			return
+		}
+	}
+}
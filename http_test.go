@@ -0,0 +1,95 @@
+package marathoner
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPStream(t *testing.T) {
+	u := NewUpdater()
+	u.update(State{
+		"/public": App{Name: "/public", Labels: map[string]string{"public": "true"}},
+		"/other":  App{Name: "/other"},
+	})
+
+	s := httptest.NewServer(http.HandlerFunc(u.serveStream))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?selector=public")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+
+	next := func() StateUpdate {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		su := StateUpdate{}
+		if err := json.Unmarshal(line, &su); err != nil {
+			t.Fatal(err)
+		}
+
+		return su
+	}
+
+	if su := next(); len(su.State) != 1 {
+		t.Fatalf("got %d apps, expected 1", len(su.State))
+	}
+
+	// change of unrelated app should not be sent
+	u.update(State{
+		"/public": App{Name: "/public", Labels: map[string]string{"public": "true"}},
+	})
+
+	u.update(State{
+		"/public": App{Name: "/public", Labels: map[string]string{"public": "true"}, Ports: []int{80}},
+	})
+
+	if su := next(); len(su.State["/public"].Ports) != 1 {
+		t.Fatalf("got unexpected update: %v", su)
+	}
+}
+
+func TestHTTPGuard(t *testing.T) {
+	u := NewUpdater()
+	u.SetRemovalGuard(0.5, 3)
+	u.update(guardTestState(4, 2))
+
+	s := httptest.NewServer(http.HandlerFunc(u.serveGuard))
+	defer s.Close()
+
+	status := func() (int, guardStatus) {
+		resp, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		g := guardStatus{}
+		if err := json.NewDecoder(resp.Body).Decode(&g); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, g
+	}
+
+	if code, g := status(); code != http.StatusOK || g.Tripped {
+		t.Fatalf("got %d %+v for guard that is not tripped", code, g)
+	}
+
+	u.update(guardTestState(1, 2))
+
+	if code, g := status(); code != http.StatusServiceUnavailable || !g.Tripped || g.Pending != 1 {
+		t.Fatalf("got %d %+v for tripped guard", code, g)
+	}
+}
@@ -1,11 +1,15 @@
 package marathoner
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
 	"math/rand"
 	"net"
 	"net/rpc"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -14,6 +18,27 @@ type Listener struct {
 	updaters []string
 	conf     ConfiguratorImplementation
 	rand     *rand.Rand
+	selector Selector
+	tls      *tls.Config
+	token    string
+	timeout  time.Duration
+	mutex    sync.Mutex
+	labels   map[string]string
+	sessions int
+	active   map[string]bool
+	applied  *StateUpdate
+	applying sync.Mutex
+	backoff  func() *Backoff
+	cache    string
+	status   ListenerStatus
+}
+
+// session is a connection loop to one updater at a time
+type session struct {
+	last    string
+	next    string
+	moved   bool
+	backoff *Backoff
 }
 
 // NewListener creates new listener for specified updater
@@ -23,42 +48,332 @@ func NewListener(updaters []string, conf ConfiguratorImplementation) *Listener {
 		updaters: updaters,
 		conf:     conf,
 		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		sessions: 1,
+		active:   map[string]bool{},
+		backoff: func() *Backoff {
+			return NewBackoff(time.Second, time.Minute, 2, 0.5)
+		},
 	}
 }
 
+// SetSelector makes listener receive only apps matching selector
+func (l *Listener) SetSelector(s Selector) {
+	l.selector = s
+}
+
+// SetLabels sets labels of listener node reported to updaters
+func (l *Listener) SetLabels(labels map[string]string) {
+	l.labels = labels
+}
+
+// SetTLSConfig makes listener connect to updaters over tls
+func (l *Listener) SetTLSConfig(c *tls.Config) {
+	l.tls = c
+}
+
+// SetToken sets shared token to authenticate listener and updaters
+func (l *Listener) SetToken(token string) {
+	l.token = token
+}
+
+// SetHeartbeatTimeout makes listener disconnect from updater if nothing
+// is received from it within timeout, updater must send heartbeats
+// more often than that, otherwise idle connections are dropped
+func (l *Listener) SetHeartbeatTimeout(timeout time.Duration) {
+	l.timeout = timeout
+}
+
+// SetSessions sets number of updaters to be connected to simultaneously,
+// only state that is newer than already applied one is applied
+func (l *Listener) SetSessions(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	l.sessions = n
+}
+
+// SetBackoff sets parameters of exponential backoff between reconnects,
+// see NewBackoff for details, backoff is reset after a session
+// that applied at least one update
+func (l *Listener) SetBackoff(min, max time.Duration, factor, jitter float64) {
+	l.backoff = func() *Backoff {
+		return NewBackoff(min, max, factor, jitter)
+	}
+}
+
+// SetCacheFile makes listener save every applied state to specified
+// file and apply saved state on start before connecting to updaters
+func (l *Listener) SetCacheFile(file string) {
+	l.cache = file
+}
+
 // Start runs infinite listener loop
 func (l *Listener) Start() {
+	if l.cache != "" {
+		l.applyCache()
+	}
+
+	for i := 1; i < l.sessions; i++ {
+		go l.session(&session{backoff: l.backoff()})
+	}
+
+	l.session(&session{backoff: l.backoff()})
+}
+
+// session runs infinite loop of connecting to updater
+// and applying state updates received from it
+func (l *Listener) session(s *session) {
 	for {
-		c, err := l.dialUpdater()
+		c, updater, err := l.dialUpdater(s)
 		if err != nil {
-			log.Println("connection error", err)
-			time.Sleep(time.Second * 3)
+			delay := s.backoff.Next()
+			log.Printf("connection error: %s, sleeping for %s\n", err, delay)
+			time.Sleep(delay)
 			continue
 		}
 
-		s := rpc.NewServer()
-		s.Register(&Configurator{l.conf})
+		conf := newConfigurator(l.apply, l.hello(), l.token)
 
-		s.ServeConn(c)
+		conf.redirect = func(a RedirectArgs) {
+			l.redirect(s, a)
+		}
+
+		conf.confirm = l.confirm
+
+		rs := rpc.NewServer()
+		rs.Register(conf)
+
+		rs.ServeConn(c)
+
+		if conf.healthy() {
+			s.backoff.Reset()
+		}
+
+		l.mutex.Lock()
+		delete(l.active, updater)
+		moved := s.moved
+		s.moved = false
+		delay := time.Duration(l.rand.Int63n(int64(time.Second)))
+		l.mutex.Unlock()
 
-		log.Println("disconnected from updater, sleeping")
-		time.Sleep(time.Second * 3)
+		if moved {
+			// small random delay to avoid reconnecting all at once,
+			// updater is shutting down, so this is not a failure
+			log.Println("redirected by updater " + updater + " on shutdown, reconnecting")
+			time.Sleep(delay)
+			continue
+		}
+
+		delay = s.backoff.Next()
+		log.Printf("disconnected from updater %s, sleeping for %s\n", updater, delay)
+		time.Sleep(delay)
 	}
 }
 
-// dialUpdater connects to random updater
-func (l *Listener) dialUpdater() (net.Conn, error) {
+// apply applies state update if it is newer than already applied one
+func (l *Listener) apply(u StateUpdate, r *bool) error {
+	l.applying.Lock()
+	defer l.applying.Unlock()
+
+	if l.applied != nil && !newerStateUpdate(u, *l.applied) {
+		log.Printf("ignoring state from %s, applied state from %s is newer\n", u.Time, l.applied.Time)
+		*r = false
+		return errStateIgnored
+	}
+
+	err := l.conf.Update(u.State, r)
+	if err != nil {
+		l.mutex.Lock()
+		l.status.Error = err.Error()
+		l.status.ErrorAt = time.Now()
+		l.mutex.Unlock()
+
+		return err
+	}
+
+	l.applied = &u
+
+	l.mutex.Lock()
+	l.status.Generation = u.Generation
+	l.status.StateTime = u.Time
+	l.status.Confirmed = u.Time
+	l.status.AppliedAt = time.Now()
+	l.status.Stale = u.Stale
+	l.status.Cached = u.Cached
+	l.status.Error = ""
+	l.mutex.Unlock()
+
+	if l.cache != "" && !u.Cached {
+		err := saveStateUpdate(l.cache, u)
+		if err != nil {
+			log.Println("error saving state to cache "+l.cache+":", err)
+		}
+	}
+
+	return nil
+}
+
+// applyCache applies state saved in cache file, if there is any
+func (l *Listener) applyCache() {
+	u, err := loadStateUpdate(l.cache)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("error loading state from cache "+l.cache+":", err)
+		}
+
+		return
+	}
+
+	u.Cached = true
+
+	log.Printf("applying cached state of generation %d, age: %s\n", u.Generation, u.Age())
+
+	r := false
+	err = l.apply(u, &r)
+	if err != nil && err != errStateIgnored {
+		log.Println("error applying cached state:", err)
+	}
+}
+
+// confirm records confirmation of applied state from updater
+func (l *Listener) confirm(a PingArgs) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.status.Stale || l.status.Cached || !a.Time.Equal(l.status.StateTime) {
+		return
+	}
+
+	if a.Confirmed.After(l.status.Confirmed) {
+		l.status.Confirmed = a.Confirmed
+	}
+}
+
+// newerStateUpdate returns true if state update a is newer than b,
+// fresh states are always newer than stale and cached ones, stale
+// states restored by updaters from disk are only newer than cached
+// ones if they were received from marathon later
+func newerStateUpdate(a, b StateUpdate) bool {
+	if a.Cached != b.Cached {
+		fresh := a
+		if a.Cached {
+			fresh = b
+		}
+
+		if fresh.Stale {
+			return !a.Time.Before(b.Time)
+		}
+
+		return !a.Cached
+	}
+
+	if a.Stale != b.Stale {
+		return !a.Stale
+	}
+
+	return !a.Time.Before(b.Time)
+}
+
+// hello returns description of listener for updaters
+func (l *Listener) hello() Hello {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Println("error getting hostname:", err)
+	}
+
+	return Hello{
+		Selector:     l.selector.String(),
+		Hostname:     hostname,
+		Version:      Version,
+		Configurator: fmt.Sprintf("%T", l.conf),
+		Labels:       l.labels,
+	}
+}
+
+// redirect makes session connect to specified updater
+// on the next attempt, any other updater if it is empty,
+// only shutdown redirects skip backoff to avoid reconnect
+// storms when all updaters are at capacity
+func (l *Listener) redirect(s *session, a RedirectArgs) {
+	l.mutex.Lock()
+	s.next = a.Updater
+	s.moved = a.Shutdown
+	l.mutex.Unlock()
+
+	reason := "is at capacity"
+	if a.Shutdown {
+		reason = "is shutting down"
+	}
+
+	if a.Updater == "" {
+		log.Println("updater " + reason + ", asked to reconnect to another updater")
+	} else {
+		log.Println("updater " + reason + ", asked to reconnect to " + a.Updater)
+	}
+}
+
+// dialUpdater connects session to random updater that is not used
+// by other sessions, previously used updater is only tried
+// if others are unreachable
+func (l *Listener) dialUpdater(s *session) (net.Conn, string, error) {
+	l.mutex.Lock()
+
+	order := []string{}
 	for _, i := range l.rand.Perm(len(l.updaters)) {
-		resp, err := net.Dial("tcp", l.updaters[i])
+		if l.updaters[i] != s.last && !l.active[l.updaters[i]] {
+			order = append(order, l.updaters[i])
+		}
+	}
+
+	if s.last != "" && !l.active[s.last] {
+		order = append(order, s.last)
+	}
+
+	if s.next != "" {
+		order = append([]string{s.next}, order...)
+		s.next = ""
+	}
+
+	l.mutex.Unlock()
+
+	for _, updater := range order {
+		resp, err := l.dial(updater)
 		if err != nil {
-			log.Println("error connecting to updater " + l.updaters[i] + ", " + err.Error())
+			log.Println("error connecting to updater " + updater + ", " + err.Error())
 			continue
 		}
 
-		log.Println("dial succeeded", l.updaters[i])
+		l.mutex.Lock()
+		if l.active[updater] {
+			// another session connected to it in the meantime
+			l.mutex.Unlock()
+			resp.Close()
+			continue
+		}
+
+		l.active[updater] = true
+		l.mutex.Unlock()
+
+		log.Println("dial succeeded", updater)
+
+		s.last = updater
+
+		if l.timeout > 0 {
+			resp = deadlineConn{resp, l.timeout}
+		}
+
+		return resp, updater, nil
+	}
+
+	return nil, "", errors.New("all available updater endpoints are unreachable")
+}
 
-		return resp, nil
+// dial connects to specified updater with tls if it is configured
+func (l *Listener) dial(updater string) (net.Conn, error) {
+	if l.tls != nil {
+		return tls.Dial("tcp", updater, l.tls)
 	}
 
-	return nil, errors.New("all updater endpoints are unreachable")
+	return net.Dial("tcp", updater)
 }
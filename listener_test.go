@@ -0,0 +1,180 @@
+package marathoner
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestNewerStateUpdate(t *testing.T) {
+	now := time.Now()
+
+	old := StateUpdate{Time: now.Add(-time.Minute)}
+	fresh := StateUpdate{Time: now}
+	stale := StateUpdate{Time: now.Add(time.Minute), Stale: true}
+
+	if !newerStateUpdate(fresh, old) {
+		t.Error("fresh state is not newer than old state")
+	}
+
+	if newerStateUpdate(old, fresh) {
+		t.Error("old state is newer than fresh state")
+	}
+
+	if newerStateUpdate(stale, old) {
+		t.Error("stale state is newer than old non-stale state")
+	}
+
+	if !newerStateUpdate(old, stale) {
+		t.Error("old non-stale state is not newer than stale state")
+	}
+
+	cached := StateUpdate{Time: now.Add(time.Minute), Cached: true}
+	if !newerStateUpdate(stale, cached) {
+		t.Error("stale state from updater is not newer than cached state of the same time")
+	}
+
+	if !newerStateUpdate(old, cached) {
+		t.Error("fresh state from updater is not newer than cached state")
+	}
+
+	if newerStateUpdate(cached, old) {
+		t.Error("cached state is newer than fresh state from updater")
+	}
+
+	older := StateUpdate{Time: now, Stale: true}
+	if newerStateUpdate(older, cached) {
+		t.Error("older stale state from updater is newer than cached state")
+	}
+
+	if !newerStateUpdate(cached, older) {
+		t.Error("cached state is not newer than older stale state from updater")
+	}
+}
+
+// countingConfigurator counts applied states
+type countingConfigurator struct {
+	applied int
+}
+
+func (c *countingConfigurator) Update(s State, r *bool) error {
+	c.applied++
+	*r = true
+	return nil
+}
+
+func TestListenerReportsIgnoredState(t *testing.T) {
+	impl := &countingConfigurator{}
+	l := NewListener(nil, impl)
+	c := newConfigurator(l.apply, Hello{}, "")
+
+	now := time.Now()
+
+	r := ApplyResult{}
+	if err := c.Apply(StateUpdate{State: State{}, Generation: 2, Time: now}, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Ignored || r.Error != "" || !r.Changed {
+		t.Fatalf("unexpected result for new state: %+v", r)
+	}
+
+	if err := c.Apply(StateUpdate{State: State{}, Generation: 1, Time: now.Add(-time.Minute)}, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Ignored || r.Error != "" || r.Changed {
+		t.Fatalf("unexpected result for older state: %+v", r)
+	}
+
+	if impl.applied != 1 || l.Status().Generation != 2 {
+		t.Fatalf("older state is applied, %d states applied, generation %d", impl.applied, l.Status().Generation)
+	}
+}
+
+func TestListenerRedirectBackoff(t *testing.T) {
+	l := NewListener([]string{"a", "b"}, &countingConfigurator{})
+	s := &session{backoff: l.backoff()}
+
+	c := newConfigurator(l.apply, Hello{}, "")
+	c.redirect = func(a RedirectArgs) {
+		l.redirect(s, a)
+	}
+
+	r := false
+	if err := c.Redirect(RedirectArgs{Updater: "b"}, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.moved || s.next != "b" {
+		t.Fatalf("capacity redirect skips backoff: moved %v, next %q", s.moved, s.next)
+	}
+
+	if err := c.Redirect(RedirectArgs{Updater: "a", Shutdown: true}, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.moved || s.next != "a" {
+		t.Fatalf("shutdown redirect does not skip backoff: moved %v, next %q", s.moved, s.next)
+	}
+}
+
+func TestListenerConfirmsStateWithSelector(t *testing.T) {
+	selector, err := ParseSelector("team=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewListener(nil, &countingConfigurator{})
+	l.SetSelector(selector)
+
+	conf := newConfigurator(l.apply, l.hello(), "")
+	conf.confirm = l.confirm
+
+	rs := rpc.NewServer()
+	rs.Register(conf)
+
+	sc, cc := net.Pipe()
+	go rs.ServeConn(sc)
+
+	c := newClient(cc)
+	defer c.Close()
+
+	if err := c.hello(""); err != nil {
+		t.Fatal(err)
+	}
+
+	a := App{Name: "/a", Labels: map[string]string{"team": "a"}}
+	b := App{Name: "/b", Labels: map[string]string{"team": "b"}}
+
+	u := NewUpdater()
+	u.update(State{"/a": a, "/b": b})
+
+	if err := u.reload(c, *u.serve); err != nil {
+		t.Fatal(err)
+	}
+
+	// unrelated app changes, nothing is sent to listener
+	b.Ports = []int{8080}
+	u.update(State{"/a": a, "/b": b})
+
+	if err := u.reload(c, *u.serve); err != nil {
+		t.Fatal(err)
+	}
+
+	// marathon returns the same state again
+	u.update(State{"/a": a, "/b": b})
+
+	if err := c.ping(u.pingArgs(c), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	u.mutex.Lock()
+	confirmed := u.confirmed
+	u.mutex.Unlock()
+
+	if s := l.Status(); s.Generation != 1 || !s.Confirmed.Equal(confirmed) {
+		t.Fatalf("state is not confirmed at %s: %+v", confirmed, s)
+	}
+}
@@ -2,7 +2,10 @@ package marathoner
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"os"
+	"time"
 )
 
 // StateLogger is configuration updater that just logs state changes
@@ -19,3 +22,17 @@ func NewStateLogger(w io.Writer) StateLogger {
 func (l StateLogger) Update(s State, r *bool) error {
 	return json.NewEncoder(l.w).Encode(s)
 }
+
+// NewStdoutStateLogger creates state logger that writes
+// states to stdout with timestamps
+func NewStdoutStateLogger() StateLogger {
+	return NewStateLogger(stdoutLogWriter{})
+}
+
+// stdoutLogWriter writes to stdout with timestamps
+type stdoutLogWriter struct{}
+
+func (s stdoutLogWriter) Write(p []byte) (n int, err error) {
+	t := time.Now().Format("2006-01-02T15:04:05.999999999Z0700") // iso8601
+	return os.Stdout.Write([]byte(fmt.Sprintf("%s: %s\n", t, string(p))))
+}
@@ -32,10 +32,16 @@ func (ma marathonApps) Swap(i, j int) {
 
 // marathonApp is an app from /v2/apps?embed=apps.tasks api endpoint
 type marathonApp struct {
-	ID     string            `json:"id"`
-	Labels map[string]string `json:"labels"`
-	Ports  []int             `json:"ports"`
-	Tasks  marathonTasks     `json:"tasks"`
+	ID              string                   `json:"id"`
+	Labels          map[string]string        `json:"labels"`
+	Ports           []int                    `json:"ports"`
+	PortDefinitions []marathonPortDefinition `json:"portDefinitions"`
+	Tasks           marathonTasks            `json:"tasks"`
+}
+
+// marathonPortDefinition is a port definition of marathon app
+type marathonPortDefinition struct {
+	Name string `json:"name"`
 }
 
 // marathonTasks is an alias for slice of marathonTask
@@ -149,10 +155,17 @@ func marathonResponseToState(mr *marathonResponse) (State, error) {
 		app, ok := state[a.ID]
 		if !ok {
 			app = App{
-				Name:   a.ID,
-				Labels: a.Labels,
-				Ports:  a.Ports,
-				Tasks:  []Task{},
+				Name:      a.ID,
+				Labels:    a.Labels,
+				Ports:     a.Ports,
+				PortNames: make([]string, len(a.Ports)),
+				Tasks:     []Task{},
+			}
+
+			for i, d := range a.PortDefinitions {
+				if i < len(app.PortNames) {
+					app.PortNames[i] = d.Name
+				}
 			}
 		}
 
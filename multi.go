@@ -0,0 +1,86 @@
+package marathoner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// multiImpl is an implementation of multi configurator
+// with the last state it successfully applied
+type multiImpl struct {
+	impl     ConfiguratorImplementation
+	optional bool
+	applied  State
+}
+
+// MultiConfigurator is configurator implementation that passes every
+// state to several implementations, failure of one implementation
+// does not prevent others from being updated. Implementations that
+// already applied the state are not updated again when it is resent.
+type MultiConfigurator struct {
+	impls []*multiImpl
+	mutex sync.Mutex
+}
+
+// NewMultiConfigurator creates configurator for specified implementations
+func NewMultiConfigurator(impls ...ConfiguratorImplementation) *MultiConfigurator {
+	m := &MultiConfigurator{}
+	for _, impl := range impls {
+		m.impls = append(m.impls, &multiImpl{impl: impl})
+	}
+
+	return m
+}
+
+// AddOptional adds implementation which failures are only logged,
+// state is considered applied even if optional implementation failed
+func (m *MultiConfigurator) AddOptional(impl ConfiguratorImplementation) {
+	m.mutex.Lock()
+	m.impls = append(m.impls, &multiImpl{impl: impl, optional: true})
+	m.mutex.Unlock()
+}
+
+// Update updates every implementation that has not applied the state yet,
+// reports reload if any of them reloaded and returns combined error
+// of failed implementations that are not optional
+func (m *MultiConfigurator) Update(s State, r *bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	errs := []string{}
+
+	for _, i := range m.impls {
+		if i.applied != nil && reflect.DeepEqual(i.applied, s) {
+			continue
+		}
+
+		reloaded := false
+
+		err := i.impl.Update(s, &reloaded)
+		if err != nil {
+			if i.optional {
+				log.Printf("error updating optional configurator %T: %s\n", i.impl, err)
+			} else {
+				errs = append(errs, fmt.Sprintf("%T: %s", i.impl, err))
+			}
+
+			continue
+		}
+
+		i.applied = s
+
+		if reloaded {
+			*r = true
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package marathoner
+
+import (
+	"errors"
+	"testing"
+)
+
+type testConfigurator struct {
+	reloaded bool
+	err      error
+	updates  int
+}
+
+func (c *testConfigurator) Update(s State, r *bool) error {
+	c.updates++
+	*r = c.reloaded
+	return c.err
+}
+
+func TestMultiConfigurator(t *testing.T) {
+	failing := &testConfigurator{err: errors.New("boom")}
+	reloading := &testConfigurator{reloaded: true}
+
+	m := NewMultiConfigurator(failing, reloading)
+
+	r := false
+	err := m.Update(State{}, &r)
+	if err == nil {
+		t.Fatal("error of failing configurator is not returned")
+	}
+
+	if reloading.updates != 1 {
+		t.Fatalf("configurator after failing one got %d updates, expected 1", reloading.updates)
+	}
+
+	if !r {
+		t.Fatal("reload is not reported")
+	}
+
+	// resent state is only applied by configurator that failed
+	r = false
+	failing.err = nil
+
+	if err := m.Update(State{}, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if failing.updates != 2 || reloading.updates != 1 {
+		t.Fatalf("got %d and %d updates, expected 2 and 1", failing.updates, reloading.updates)
+	}
+
+	if r {
+		t.Fatal("reload is reported by configurator that did not reload")
+	}
+
+	if err := m.Update(State{"/app": App{Name: "/app"}}, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if failing.updates != 3 || reloading.updates != 2 {
+		t.Fatalf("got %d and %d updates for new state, expected 3 and 2", failing.updates, reloading.updates)
+	}
+}
+
+func TestMultiConfiguratorOptional(t *testing.T) {
+	required := &testConfigurator{}
+	optional := &testConfigurator{err: errors.New("boom")}
+
+	m := NewMultiConfigurator(required)
+	m.AddOptional(optional)
+
+	r := false
+	if err := m.Update(State{}, &r); err != nil {
+		t.Fatalf("error of optional configurator is returned: %s", err)
+	}
+
+	if required.updates != 1 || optional.updates != 1 {
+		t.Fatalf("got %d and %d updates, expected 1 and 1", required.updates, optional.updates)
+	}
+}
@@ -0,0 +1,84 @@
+package marathoner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeFileAtomic writes data to a temporary file in the same
+// directory and renames it over the destination afterwards
+func writeFileAtomic(file string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), file)
+}
+
+// saveStateUpdate atomically writes state update to a file in json format
+func saveStateUpdate(file string, u StateUpdate) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, b)
+}
+
+// loadStateUpdate reads state update previously written by saveStateUpdate
+func loadStateUpdate(file string) (StateUpdate, error) {
+	u := StateUpdate{}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return u, err
+	}
+
+	err = json.Unmarshal(b, &u)
+
+	return u, err
+}
+
+// ParseState parses state from json saved by updater or listener,
+// returned by updater over http, or written by logger, in which case
+// the last line is used and its timestamp prefix is ignored
+func ParseState(b []byte) (State, error) {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	line := lines[len(lines)-1]
+
+	if i := strings.Index(line, "{"); i > 0 {
+		line = line[i:]
+	}
+
+	u := StateUpdate{}
+	err := json.Unmarshal([]byte(line), &u)
+	if err == nil && u.State != nil {
+		return u.State, nil
+	}
+
+	s := State{}
+	err = json.Unmarshal([]byte(line), &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,27 @@
+package marathoner
+
+import "testing"
+
+func TestParseState(t *testing.T) {
+	inputs := map[string]string{
+		"state":        `{"/app":{"Name":"/app","Ports":[1234]}}`,
+		"state update": `{"State":{"/app":{"Name":"/app","Ports":[1234]}},"Generation":3}`,
+		"logger": "2016-01-01T00:00:00Z: {\"/old\":{\"Name\":\"/old\"}}\n\n" +
+			"2016-01-01T00:00:01Z: {\"/app\":{\"Name\":\"/app\",\"Ports\":[1234]}}\n\n",
+	}
+
+	for name, input := range inputs {
+		s, err := ParseState([]byte(input))
+		if err != nil {
+			t.Fatalf("error parsing %s: %s", name, err)
+		}
+
+		if len(s) != 1 || s["/app"].Ports[0] != 1234 {
+			t.Fatalf("unexpected state parsed from %s: %+v", name, s)
+		}
+	}
+
+	if _, err := ParseState([]byte("not json")); err == nil {
+		t.Fatal("invalid state is parsed")
+	}
+}
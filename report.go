@@ -0,0 +1,98 @@
+package marathoner
+
+import (
+	"log"
+	"time"
+)
+
+// reportsToKeep is the number of latest generations to keep reports for
+const reportsToKeep = 10
+
+// ApplyResult is a result of state update applied by listener
+type ApplyResult struct {
+	// Generation is a generation of applied state
+	Generation uint64
+	// Changed is true if configuration was changed
+	Changed bool
+	// Error is a description of the error if apply failed
+	Error string
+	// Ignored is true if state was not applied,
+	// because listener has already applied newer state
+	Ignored bool
+	// Duration is how long it took to apply the state
+	Duration time.Duration
+}
+
+// GenerationReport aggregates apply results from listeners
+// for a single generation of state
+type GenerationReport struct {
+	Generation uint64
+	Time       time.Time
+	Applied    int
+	Changed    int
+	Ignored    int
+	Failed     map[string]string
+}
+
+// generationReports keeps reports for the latest generations
+type generationReports struct {
+	reports []*GenerationReport
+}
+
+// add starts a report for a new generation
+func (g *generationReports) add(generation uint64, t time.Time) {
+	g.reports = append(g.reports, &GenerationReport{
+		Generation: generation,
+		Time:       t,
+		Failed:     map[string]string{},
+	})
+
+	if len(g.reports) > reportsToKeep {
+		g.reports = g.reports[len(g.reports)-reportsToKeep:]
+	}
+}
+
+// record adds apply result of specified client to its generation report
+func (g *generationReports) record(client string, r ApplyResult) {
+	for _, report := range g.reports {
+		if report.Generation != r.Generation {
+			continue
+		}
+
+		if r.Error != "" {
+			report.Failed[client] = r.Error
+			return
+		}
+
+		if r.Ignored {
+			report.Ignored++
+			return
+		}
+
+		report.Applied++
+		if r.Changed {
+			report.Changed++
+		}
+
+		return
+	}
+
+	log.Printf("apply result for unknown generation %d from %s\n", r.Generation, client)
+}
+
+// list returns copies of kept reports from the oldest to the latest
+func (g *generationReports) list() []GenerationReport {
+	r := make([]GenerationReport, 0, len(g.reports))
+
+	for _, report := range g.reports {
+		c := *report
+		c.Failed = make(map[string]string, len(report.Failed))
+		for n, e := range report.Failed {
+			c.Failed[n] = e
+		}
+
+		r = append(r, c)
+	}
+
+	return r
+}
@@ -0,0 +1,80 @@
+package marathoner
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGenerationReportsRecord(t *testing.T) {
+	g := generationReports{}
+	g.add(1, time.Now())
+	g.add(2, time.Now())
+
+	g.record("a", ApplyResult{Generation: 1, Changed: true})
+	g.record("b", ApplyResult{Generation: 2})
+	g.record("c", ApplyResult{Generation: 2, Changed: true})
+	g.record("d", ApplyResult{Generation: 2, Error: "broken"})
+	g.record("e", ApplyResult{Generation: 3})
+	g.record("f", ApplyResult{Generation: 2, Ignored: true})
+
+	reports := g.list()
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, expected 2", len(reports))
+	}
+
+	if r := reports[0]; r.Generation != 1 || r.Applied != 1 || r.Changed != 1 || len(r.Failed) != 0 {
+		t.Fatalf("unexpected report for generation 1: %+v", r)
+	}
+
+	if r := reports[1]; r.Generation != 2 || r.Applied != 2 || r.Changed != 1 || r.Ignored != 1 || r.Failed["d"] != "broken" {
+		t.Fatalf("unexpected report for generation 2: %+v", r)
+	}
+
+	// reports are copies, changing them does not affect kept ones
+	reports[1].Failed["x"] = "changed"
+
+	if _, ok := g.list()[1].Failed["x"]; ok {
+		t.Fatal("list returned report sharing failures with kept one")
+	}
+}
+
+func TestGenerationReportsKeepsLatest(t *testing.T) {
+	g := generationReports{}
+	for i := uint64(1); i <= reportsToKeep+5; i++ {
+		g.add(i, time.Now())
+	}
+
+	reports := g.list()
+	if len(reports) != reportsToKeep {
+		t.Fatalf("got %d reports, expected %d", len(reports), reportsToKeep)
+	}
+
+	if reports[0].Generation != 6 || reports[len(reports)-1].Generation != reportsToKeep+5 {
+		t.Fatalf("got generations from %d to %d", reports[0].Generation, reports[len(reports)-1].Generation)
+	}
+}
+
+func TestUpdaterReportsTransportFailure(t *testing.T) {
+	u := NewUpdater()
+	u.update(State{"/app": App{Name: "/app"}})
+
+	sc, cc := net.Pipe()
+	sc.Close()
+
+	c := newClient(cc)
+	defer c.Close()
+
+	if err := u.reload(c, *u.serve); err == nil {
+		t.Fatal("reload over closed connection succeeded")
+	}
+
+	reports := u.Reports()
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, expected 1", len(reports))
+	}
+
+	if r := reports[0]; r.Applied != 0 || r.Failed[c.name] == "" {
+		t.Fatalf("transport failure is not reported: %+v", r)
+	}
+}
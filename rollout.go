@@ -0,0 +1,115 @@
+package marathoner
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// canaryRollout tracks apply results from canaries
+// for a generation of state that is being rolled out
+type canaryRollout struct {
+	generation uint64
+	canaries   map[string]bool
+	results    chan ApplyResult
+}
+
+// report passes apply result of a canary to the rollout
+func (r *canaryRollout) report(client string, result ApplyResult) {
+	if r == nil || result.Generation != r.generation || !r.canaries[client] {
+		return
+	}
+
+	delete(r.canaries, client)
+	r.results <- result
+}
+
+// SetCanary makes updater roll out every new state to specified
+// percentage of rpc clients first, the rest of clients get new state
+// only if canaries applied it without errors within specified time,
+// otherwise the rest of clients keep the previous state
+func (u *Updater) SetCanary(percent float64, wait time.Duration) {
+	u.mutex.Lock()
+	u.canary = percent
+	u.wait = wait
+	u.mutex.Unlock()
+}
+
+// pickCanaries picks random canaries among rpc clients,
+// nothing is picked if there is nothing to compare with
+func (u *Updater) pickCanaries(clients map[string]chan StateUpdate) map[string]chan StateUpdate {
+	if u.canary <= 0 || u.serve == nil {
+		return nil
+	}
+
+	names := []string{}
+	for n := range u.peers {
+		if _, ok := clients[n]; ok {
+			names = append(names, n)
+		}
+	}
+
+	count := int(math.Ceil(float64(len(names)) * u.canary / 100))
+	if count >= len(names) {
+		return nil
+	}
+
+	r := map[string]chan StateUpdate{}
+	for _, i := range u.rand.Perm(len(names))[:count] {
+		r[names[i]] = clients[names[i]]
+	}
+
+	return r
+}
+
+// rollout sends state update to canaries and returns true if every
+// canary applied it successfully within configured time
+func (u *Updater) rollout(su StateUpdate, canaries map[string]chan StateUpdate) bool {
+	rolling := &canaryRollout{
+		generation: su.Generation,
+		canaries:   map[string]bool{},
+		results:    make(chan ApplyResult, len(canaries)),
+	}
+
+	for n := range canaries {
+		rolling.canaries[n] = true
+	}
+
+	u.mutex.Lock()
+	u.rolling = rolling
+	wait := u.wait
+	u.mutex.Unlock()
+
+	defer func() {
+		u.mutex.Lock()
+		u.rolling = nil
+		u.mutex.Unlock()
+	}()
+
+	log.Printf("rolling out generation %d to %d canaries\n", su.Generation, len(canaries))
+
+	u.distribute(su, canaries)
+
+	deadline := time.After(wait)
+	for i := 0; i < len(canaries); i++ {
+		select {
+		case r := <-rolling.results:
+			if r.Error != "" {
+				log.Printf("ALERT: canary failed to apply generation %d, holding previous generation: %s\n", su.Generation, r.Error)
+				return false
+			}
+
+			if r.Ignored {
+				log.Printf("ALERT: canary ignored generation %d in favour of newer state, holding previous generation\n", su.Generation)
+				return false
+			}
+		case <-deadline:
+			log.Printf("ALERT: canaries did not apply generation %d in %s, holding previous generation\n", su.Generation, wait)
+			return false
+		}
+	}
+
+	log.Printf("canaries applied generation %d, rolling out to the rest\n", su.Generation)
+
+	return true
+}
@@ -0,0 +1,150 @@
+package marathoner
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rolloutTestUpdater returns updater with specified number of rpc
+// clients that report results from apply function to rollouts
+func rolloutTestUpdater(n int, apply func(name string, su StateUpdate) ApplyResult) *Updater {
+	u := NewUpdater()
+	u.update(State{"/app": App{Name: "/app"}})
+
+	for i := 0; i < n; i++ {
+		name := "client" + strconv.Itoa(i)
+		ch := make(chan StateUpdate)
+
+		u.clients[name] = ch
+		u.peers[name] = &client{name: name}
+
+		go func() {
+			for su := range ch {
+				r := apply(name, su)
+
+				u.mutex.Lock()
+				u.rolling.report(name, r)
+				u.mutex.Unlock()
+			}
+		}()
+	}
+
+	return u
+}
+
+func TestPickCanaries(t *testing.T) {
+	u := rolloutTestUpdater(20, func(string, StateUpdate) ApplyResult { return ApplyResult{} })
+
+	if c := u.pickCanaries(u.clients); c != nil {
+		t.Fatalf("canaries picked without canary percent: %v", c)
+	}
+
+	u.SetCanary(10, time.Second)
+
+	if c := u.pickCanaries(u.clients); len(c) != 2 {
+		t.Fatalf("got %d canaries, expected 2", len(c))
+	}
+
+	u.SetCanary(1, time.Second)
+
+	if c := u.pickCanaries(u.clients); len(c) != 1 {
+		t.Fatalf("got %d canaries, expected 1", len(c))
+	}
+
+	u.SetCanary(100, time.Second)
+
+	if c := u.pickCanaries(u.clients); c != nil {
+		t.Fatalf("canaries picked when every client is canary: %v", c)
+	}
+
+	u.SetCanary(10, time.Second)
+	u.serve = nil
+
+	if c := u.pickCanaries(u.clients); c != nil {
+		t.Fatalf("canaries picked without previous state: %v", c)
+	}
+}
+
+func TestRollout(t *testing.T) {
+	cases := map[string]struct {
+		apply    func(string, StateUpdate) ApplyResult
+		expected bool
+	}{
+		"success": {
+			apply: func(_ string, su StateUpdate) ApplyResult {
+				return ApplyResult{Generation: su.Generation}
+			},
+			expected: true,
+		},
+		"failure": {
+			apply: func(_ string, su StateUpdate) ApplyResult {
+				return ApplyResult{Generation: su.Generation, Error: "broken"}
+			},
+			expected: false,
+		},
+		"ignored": {
+			apply: func(_ string, su StateUpdate) ApplyResult {
+				return ApplyResult{Generation: su.Generation, Ignored: true}
+			},
+			expected: false,
+		},
+		"timeout": {
+			apply: func(_ string, su StateUpdate) ApplyResult {
+				time.Sleep(time.Millisecond * 200)
+				return ApplyResult{Generation: su.Generation}
+			},
+			expected: false,
+		},
+	}
+
+	for name, c := range cases {
+		u := rolloutTestUpdater(4, c.apply)
+		u.SetCanary(50, time.Millisecond*100)
+
+		canaries := u.pickCanaries(u.clients)
+		su := StateUpdate{State: State{}, Generation: 2, Time: time.Now()}
+
+		if r := u.rollout(su, canaries); r != c.expected {
+			t.Fatalf("%s: rollout returned %v, expected %v", name, r, c.expected)
+		}
+	}
+}
+
+func TestRolloutLateClient(t *testing.T) {
+	late := make(chan StateUpdate, 1)
+	once := sync.Once{}
+
+	var u *Updater
+	u = rolloutTestUpdater(2, func(name string, su StateUpdate) ApplyResult {
+		once.Do(func() {
+			// the first client to get new state is canary,
+			// another client connects while canary applies it
+			initial, ch, _ := u.subscribe("late")
+			if initial.Generation != 1 {
+				t.Errorf("late client got generation %d during rollout, expected 1", initial.Generation)
+			}
+
+			go func() {
+				for su := range ch {
+					late <- su
+				}
+			}()
+		})
+
+		return ApplyResult{Generation: su.Generation}
+	})
+
+	u.SetCanary(50, time.Second)
+	u.update(State{"/app": App{Name: "/app", Ports: []int{80}}})
+
+	select {
+	case su := <-late:
+		if su.Generation != 2 {
+			t.Fatalf("late client got generation %d, expected 2", su.Generation)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("late client did not get new state after rollout")
+	}
+}
@@ -0,0 +1,190 @@
+package marathoner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// HaproxySlot is a pre-allocated server slot of haproxy app,
+// disabled slots are placeholders for servers to be added
+// at runtime without reloading haproxy
+type HaproxySlot struct {
+	Name    string
+	Host    string
+	Port    int
+	Enabled bool
+}
+
+// slotCount returns number of slots to allocate for specified
+// number of servers, it is the smallest power of two multiple
+// of min slots that fits every server
+func slotCount(servers, min int) int {
+	if min <= 0 {
+		return servers
+	}
+
+	n := min
+	for n < servers {
+		n *= 2
+	}
+
+	return n
+}
+
+// assignSlots puts servers of next apps into slots, keeping servers
+// in the same slots they had in previous apps if possible,
+// slots are not reused if min slots is not set
+func assignSlots(prev, next map[int]HaproxyApp, min int) {
+	for port, app := range next {
+		size := slotCount(len(app.Servers), min)
+
+		taken := map[string]int{}
+
+		p, ok := prev[port]
+		if ok && min > 0 && len(p.Slots) >= len(app.Servers) && len(p.Slots) <= size*2 {
+			size = len(p.Slots)
+
+			for i, s := range p.Slots {
+				if s.Enabled {
+					taken[fmt.Sprintf("%s:%d", s.Host, s.Port)] = i
+				}
+			}
+		}
+
+		app.Slots = make([]HaproxySlot, size)
+		for i := range app.Slots {
+			app.Slots[i].Name = fmt.Sprintf("srv%d", i+1)
+		}
+
+		pending := []HaproxyServer{}
+		for _, s := range app.Servers {
+			i, ok := taken[fmt.Sprintf("%s:%d", s.Host, s.Port)]
+			if !ok {
+				pending = append(pending, s)
+				continue
+			}
+
+			app.Slots[i].Host = s.Host
+			app.Slots[i].Port = s.Port
+			app.Slots[i].Enabled = true
+		}
+
+		for i := range app.Slots {
+			if len(pending) == 0 {
+				break
+			}
+
+			if app.Slots[i].Enabled {
+				continue
+			}
+
+			app.Slots[i].Host = pending[0].Host
+			app.Slots[i].Port = pending[0].Port
+			app.Slots[i].Enabled = true
+
+			pending = pending[1:]
+		}
+
+		next[port] = app
+	}
+}
+
+// sameStructure returns true if apps differ only in contents
+// of their slots, so they can be updated at runtime
+func sameStructure(prev, next map[int]HaproxyApp) bool {
+	if len(prev) != len(next) {
+		return false
+	}
+
+	for port, n := range next {
+		p, ok := prev[port]
+		if !ok {
+			return false
+		}
+
+		if p.Backend != n.Backend || len(p.Slots) != len(n.Slots) || !reflect.DeepEqual(p.Labels, n.Labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// runtimeCommands returns haproxy runtime api commands
+// to turn slots of previous apps into slots of next apps
+func runtimeCommands(prev, next map[int]HaproxyApp) ([]string, error) {
+	cmds := []string{}
+
+	for port, n := range next {
+		p := prev[port]
+
+		for i, s := range n.Slots {
+			if p.Slots[i] == s {
+				continue
+			}
+
+			server := n.Backend + "/" + s.Name
+
+			if !s.Enabled {
+				cmds = append(cmds, "set server "+server+" state maint")
+				continue
+			}
+
+			ips, err := net.LookupIP(s.Host)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(ips) == 0 {
+				return nil, errors.New("no addresses found for " + s.Host)
+			}
+
+			cmds = append(cmds, fmt.Sprintf("set server %s addr %s port %d", server, ips[0], s.Port))
+			cmds = append(cmds, "set server "+server+" state ready")
+		}
+	}
+
+	return cmds, nil
+}
+
+// runRuntimeCommands runs commands on haproxy runtime api
+// available on specified unix socket
+func runRuntimeCommands(socket string, cmds []string) error {
+	conn, err := net.DialTimeout("unix", socket, time.Second*5)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(time.Second * 10))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write([]byte(strings.Join(cmds, ";") + "\n"))
+	if err != nil {
+		return err
+	}
+
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, "changed from") || strings.Contains(line, "no need to change") {
+			continue
+		}
+
+		return errors.New("haproxy runtime api error: " + line)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package marathoner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selectorRequirement is a single requirement on app labels
+type selectorRequirement struct {
+	key    string
+	value  string
+	op     string
+	exists bool
+}
+
+// matches returns true if labels satisfy requirement
+func (r selectorRequirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+
+	switch r.op {
+	case "=":
+		return ok && v == r.value
+	case "!=":
+		return !ok || v != r.value
+	}
+
+	return ok == r.exists
+}
+
+// Selector selects apps by their labels. Selector is a comma separated
+// list of requirements, all of which must be satisfied:
+//
+//	key=value   label is set to value
+//	key!=value  label is not set or set to another value
+//	key         label is set
+//	!key        label is not set
+//
+// Empty selector matches every app.
+type Selector struct {
+	requirements []selectorRequirement
+	source       string
+}
+
+// ParseSelector parses selector from string
+func ParseSelector(s string) (Selector, error) {
+	r := Selector{source: strings.TrimSpace(s)}
+
+	if r.source == "" {
+		return r, nil
+	}
+
+	for _, p := range strings.Split(r.source, ",") {
+		p = strings.TrimSpace(p)
+
+		req := selectorRequirement{}
+
+		switch {
+		case strings.Contains(p, "!="):
+			parts := strings.SplitN(p, "!=", 2)
+			req.key, req.value, req.op = parts[0], parts[1], "!="
+		case strings.Contains(p, "="):
+			parts := strings.SplitN(p, "=", 2)
+			req.key, req.value, req.op = parts[0], parts[1], "="
+		case strings.HasPrefix(p, "!"):
+			req.key = p[1:]
+		default:
+			req.key, req.exists = p, true
+		}
+
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+
+		if req.key == "" {
+			return Selector{}, fmt.Errorf("invalid selector requirement %q", p)
+		}
+
+		r.requirements = append(r.requirements, req)
+	}
+
+	return r, nil
+}
+
+// String returns selector in the form that ParseSelector accepts
+func (s Selector) String() string {
+	return s.source
+}
+
+// Empty returns true if selector matches everything
+func (s Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+// Matches returns true if labels satisfy every requirement of selector
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s.requirements {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Filter returns subset of state with apps matching selector
+func (s Selector) Filter(state State) State {
+	if s.Empty() {
+		return state
+	}
+
+	r := State{}
+	for n, a := range state {
+		if s.Matches(a.Labels) {
+			r[n] = a
+		}
+	}
+
+	return r
+}
+
+// ParseLabels parses comma separated list of key=value pairs
+func ParseLabels(s string) (map[string]string, error) {
+	r := map[string]string{}
+
+	if strings.TrimSpace(s) == "" {
+		return r, nil
+	}
+
+	for _, p := range strings.Split(s, ",") {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", p)
+		}
+
+		r[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return r, nil
+}
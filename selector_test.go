@@ -0,0 +1,60 @@
+package marathoner
+
+import "testing"
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{
+		"public": "true",
+		"team":   "search",
+	}
+
+	cases := map[string]bool{
+		"":                      true,
+		"public":                true,
+		"public=true":           true,
+		"public=false":          false,
+		"team!=search":          false,
+		"team!=ads":             true,
+		"!internal":             true,
+		"!public":               false,
+		"public=true,team=ads":  false,
+		" public=true , team  ": true,
+		"missing!=whatever":     true,
+	}
+
+	for selector, expected := range cases {
+		s, err := ParseSelector(selector)
+		if err != nil {
+			t.Fatalf("error parsing selector %q: %s", selector, err)
+		}
+
+		if s.Matches(labels) != expected {
+			t.Errorf("selector %q matched %v, expected %v", selector, !expected, expected)
+		}
+	}
+}
+
+func TestSelectorFilter(t *testing.T) {
+	state := State{
+		"/public":   App{Name: "/public", Labels: map[string]string{"public": "true"}},
+		"/internal": App{Name: "/internal"},
+	}
+
+	s, err := ParseSelector("public=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := s.Filter(state)
+	if len(f) != 1 || f["/public"].Name != "/public" {
+		t.Fatalf("unexpected filtered state: %v", f)
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{"public=true,", "=true", "!"} {
+		if _, err := ParseSelector(selector); err == nil {
+			t.Errorf("selector %q parsed without errors", selector)
+		}
+	}
+}
@@ -1,14 +1,38 @@
 package marathoner
 
+import "time"
+
 // State is a snapshot of running apps and tasks on marathon
 type State map[string]App
 
-// App is marathon app with name, ports and tasks
+// StateUpdate is a state distributed by updater with its metadata
+type StateUpdate struct {
+	State State
+	// Generation is a sequence number of state on updater
+	Generation uint64
+	// Time is when the state was received from marathon
+	Time time.Time
+	// Stale is true if state was not received from marathon
+	// by updater, but restored from disk after restart
+	Stale bool
+	// Cached is true if state was loaded by listener from its
+	// cache on start, before connecting to updaters
+	Cached bool
+}
+
+// Age returns time passed since state was received from marathon
+func (u StateUpdate) Age() time.Duration {
+	return time.Since(u.Time)
+}
+
+// App is marathon app with name, ports and tasks,
+// port names are empty for ports without names
 type App struct {
-	Name   string
-	Labels map[string]string
-	Ports  []int
-	Tasks  []Task
+	Name      string
+	Labels    map[string]string
+	Ports     []int
+	PortNames []string
+	Tasks     []Task
 }
 
 // Task is marathon task with id, host and port
@@ -0,0 +1,95 @@
+package marathoner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ListenerStatus describes current state of listener
+type ListenerStatus struct {
+	// Updaters are updaters listener is connected to
+	Updaters []string
+	// Generation is generation of applied state on its updater
+	Generation uint64
+	// StateTime is when applied state was received from marathon
+	StateTime time.Time
+	// Confirmed is the last time applied state was confirmed by updater
+	Confirmed time.Time
+	// AppliedAt is when state was applied
+	AppliedAt time.Time
+	// Stale is true if applied state was restored by updater from disk
+	Stale bool
+	// Cached is true if applied state was loaded from listener cache
+	Cached bool
+	// Error is the error of the last apply, empty if it succeeded
+	Error string
+	// ErrorAt is when the last failed apply happened
+	ErrorAt time.Time
+}
+
+// Status returns current status of listener
+func (l *Listener) Status() ListenerStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	s := l.status
+	s.Updaters = []string{}
+	for u := range l.active {
+		s.Updaters = append(s.Updaters, u)
+	}
+
+	sort.Strings(s.Updaters)
+
+	return s
+}
+
+// healthy returns nil if listener applied state that was confirmed
+// by updater no longer than max age ago, max age of 0 means any age
+func (s ListenerStatus) healthy(maxAge time.Duration) error {
+	if s.AppliedAt.IsZero() {
+		return fmt.Errorf("no state is applied")
+	}
+
+	if s.Error != "" {
+		return fmt.Errorf("last apply failed: %s", s.Error)
+	}
+
+	if maxAge > 0 && time.Since(s.Confirmed) > maxAge {
+		return fmt.Errorf("state was last confirmed %s ago", time.Since(s.Confirmed))
+	}
+
+	return nil
+}
+
+// ListenForStatus starts serving listener status over http on specified
+// location. The following endpoints are available:
+//
+//	/status  current status as json object
+//	/health  200 if listener is healthy, 503 otherwise
+//
+// Listener is healthy if the last apply succeeded and applied state
+// was confirmed by updater no longer than max age ago. Updater confirms
+// state with heartbeats, so max age check needs heartbeats enabled.
+func (l *Listener) ListenForStatus(listen string, maxAge time.Duration) error {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.Status())
+	})
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		err := l.Status().healthy(maxAge)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+
+	return http.ListenAndServe(listen, mux)
+}
@@ -0,0 +1,65 @@
+package marathoner
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// haproxyIdentifierInvalid matches characters not allowed in haproxy identifiers
+var haproxyIdentifierInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.:-]`)
+
+// TemplateFuncs returns functions for config templates:
+//
+//	label $app.Labels "key" "default"  label value or default if it is not set
+//	sanitize "/group/app"               string usable as haproxy identifier
+//	sortStrings $list                   sorted copy of list of strings
+//	split "a,b" ","                     list of substrings separated by separator
+//	join $list ","                      list of strings joined with separator
+//	env "NAME" "default"                environment variable or default if it is empty
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"label":       templateLabel,
+		"sanitize":    templateSanitize,
+		"sortStrings": templateSortStrings,
+		"split":       strings.Split,
+		"join":        strings.Join,
+		"env":         templateEnv,
+	}
+}
+
+// templateLabel returns label value or default if label is not set
+func templateLabel(labels map[string]string, key, def string) string {
+	if v, ok := labels[key]; ok {
+		return v
+	}
+
+	return def
+}
+
+// templateSanitize replaces characters that are not allowed
+// in haproxy identifiers with underscores
+func templateSanitize(s string) string {
+	return haproxyIdentifierInvalid.ReplaceAllString(strings.TrimPrefix(s, "/"), "_")
+}
+
+// templateSortStrings returns sorted copy of list of strings
+func templateSortStrings(list []string) []string {
+	r := make([]string, len(list))
+	copy(r, list)
+
+	sort.Strings(r)
+
+	return r
+}
+
+// templateEnv returns environment variable or default if it is empty
+func templateEnv(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
@@ -0,0 +1,50 @@
+package marathoner
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"text/template"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	os.Setenv("MARATHONER_TEST_ENV", "from-env")
+	defer os.Unsetenv("MARATHONER_TEST_ENV")
+
+	apps := stateToApps(State{
+		"/group/web": App{
+			Name:      "/group/web",
+			Ports:     []int{1234, 1235},
+			PortNames: []string{"http", ""},
+			Labels: map[string]string{
+				"marathoner_haproxy_enabled": "true",
+				"tags":                       "b,c,a",
+			},
+			Tasks: []Task{
+				{ID: "web.1", Host: "10.0.0.1", Ports: []int{31000, 31001}, StartedAt: "2016-01-01T00:00:00.000Z"},
+			},
+		},
+	})
+
+	tmpl := template.Must(template.New("test").Funcs(TemplateFuncs()).Parse(
+		`{{ range $app := .Apps }}` +
+			`{{ sanitize $app.ID }}-{{ $app.PortIndex }}-{{ label $app.Labels "name" $app.PortName }} ` +
+			`{{ join (sortStrings (split (index $app.Labels "tags") ",")) "+" }} ` +
+			`{{ range $app.Servers }}{{ .TaskID }}@{{ .StartedAt }}{{ end }}` +
+			"\n{{ end }}{{ env \"MARATHONER_TEST_ENV\" \"default\" }} {{ env \"MARATHONER_TEST_UNSET\" \"default\" }}",
+	))
+
+	b := bytes.Buffer{}
+	err := tmpl.Execute(&b, haproxyConfigContext{Apps: apps})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "group_web-0-http a+b+c web.1@2016-01-01T00:00:00.000Z\n" +
+		"group_web-1- a+b+c web.1@2016-01-01T00:00:00.000Z\n" +
+		"from-env default"
+
+	if b.String() != expected {
+		t.Fatalf("got output %q, expected %q", b.String(), expected)
+	}
+}
@@ -1,8 +1,11 @@
 package marathoner
 
 import (
+	"crypto/tls"
 	"log"
+	"math/rand"
 	"net"
+	"os"
 	"reflect"
 	"sync"
 	"time"
@@ -11,17 +14,154 @@ import (
 // Updater is update coordinator
 type Updater struct {
 	mutex   sync.Mutex
-	state   State
+	state   *StateUpdate
+	serve   *StateUpdate
 	updates chan State
-	clients map[string]chan State
+	clients map[string]chan StateUpdate
+	peers   map[string]*client
+	guard   *removalGuard
+	file    string
+	tls     *tls.Config
+	token   string
+	beat    time.Duration
+	timeout time.Duration
+
+	listener net.Listener
+	done     chan struct{}
+	sessions sync.WaitGroup
+	redirect string
+	max      int
+	active   int
+
+	generation uint64
+	reports    generationReports
+	confirmed  time.Time
+
+	canary  float64
+	wait    time.Duration
+	rand    *rand.Rand
+	rolling *canaryRollout
 }
 
 // NewUpdater creates new updater
 func NewUpdater() *Updater {
 	return &Updater{
 		mutex:   sync.Mutex{},
-		clients: map[string]chan State{},
+		clients: map[string]chan StateUpdate{},
+		peers:   map[string]*client{},
+		done:    make(chan struct{}),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetRedirect sets updater to send clients to when this updater
+// is shutting down or has too many clients, empty updater lets
+// clients pick any other updater
+func (u *Updater) SetRedirect(updater string) {
+	u.redirect = updater
+}
+
+// SetMaxClients sets maximum number of rpc clients,
+// new clients above the limit are redirected, 0 is unlimited
+func (u *Updater) SetMaxClients(max int) {
+	u.max = max
+}
+
+// Shutdown stops accepting new clients, redirects connected clients
+// and waits up to drain timeout for them to disconnect
+func (u *Updater) Shutdown(drain time.Duration) {
+	u.mutex.Lock()
+	select {
+	case <-u.done:
+		u.mutex.Unlock()
+		return
+	default:
+	}
+
+	close(u.done)
+
+	if u.listener != nil {
+		u.listener.Close()
 	}
+
+	log.Printf("shutting down, redirecting %d clients\n", u.active)
+	u.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		u.sessions.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("all clients are redirected")
+	case <-time.After(drain):
+		log.Println("drain timeout exceeded, some clients are still connected")
+	}
+}
+
+// SetTLSConfig makes updater accept only tls connections from clients
+func (u *Updater) SetTLSConfig(c *tls.Config) {
+	u.tls = c
+}
+
+// SetToken sets shared token to authenticate updater and its clients
+func (u *Updater) SetToken(token string) {
+	u.token = token
+}
+
+// SetHeartbeat makes updater ping clients with specified interval
+// and disconnect them if they fail to respond within timeout
+func (u *Updater) SetHeartbeat(interval, timeout time.Duration) {
+	u.beat = interval
+	u.timeout = timeout
+}
+
+// SetStateFile makes updater persist every distributed state to specified
+// file and loads previously saved state from it, so listeners could get
+// state immediately, even if marathon is not reachable after restart
+func (u *Updater) SetStateFile(file string) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.file = file
+
+	su, err := loadStateUpdate(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	su.Stale = true
+	u.state = &su
+	u.serve = &su
+	u.generation = su.Generation
+
+	log.Printf("loaded stale state from %s, age: %s\n", file, su.Age())
+
+	return nil
+}
+
+// SetRemovalGuard makes updater hold back states that remove more than
+// specified fraction of apps or tasks, unless such state is returned
+// by marathon for the specified number of consecutive polls
+func (u *Updater) SetRemovalGuard(fraction float64, polls int) {
+	u.mutex.Lock()
+	u.guard = newRemovalGuard(fraction, polls)
+	u.mutex.Unlock()
+}
+
+// GuardTripped returns true if updater is currently holding back
+// a state because of removal guard, alerts should be based on this
+func (u *Updater) GuardTripped() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.guard != nil && u.guard.pending > 0
 }
 
 // ListenForUpdates starts listening for marathon state updates
@@ -46,25 +186,83 @@ func (u *Updater) ListenForUpdates(marathon []string, interval time.Duration) {
 func (u *Updater) update(s State) {
 	u.mutex.Lock()
 
-	if reflect.DeepEqual(u.state, s) {
+	var current State
+	if u.state != nil {
+		current = u.state.State
+
+		if !u.state.Stale && reflect.DeepEqual(current, s) {
+			u.guard.reset()
+
+			if u.serve == u.state {
+				u.confirmed = time.Now()
+			}
+
+			u.mutex.Unlock()
+			return
+		}
+	}
+
+	err := u.guard.check(current, s)
+	if err != nil {
 		u.mutex.Unlock()
+		log.Println("ALERT:", err)
 		return
 	}
 
-	u.state = s
+	u.generation++
 
-	clients := u.clients
+	su := StateUpdate{
+		State:      s,
+		Generation: u.generation,
+		Time:       time.Now(),
+	}
+
+	u.state = &su
+	u.reports.add(su.Generation, su.Time)
+
+	canaries := u.pickCanaries(u.clients)
 	u.mutex.Unlock()
 
-	log.Printf("distributing update among %d clients\n", len(clients))
+	if len(canaries) > 0 && !u.rollout(su, canaries) {
+		return
+	}
+
+	u.mutex.Lock()
+	u.serve = &su
+	u.confirmed = su.Time
+	file := u.file
+
+	// clients could have subscribed to previous state during rollout,
+	// clients subscribing from now on get this state on subscription
+	clients := make(map[string]chan StateUpdate, len(u.clients))
+	for n, c := range u.clients {
+		if _, ok := canaries[n]; !ok {
+			clients[n] = c
+		}
+	}
+	u.mutex.Unlock()
+
+	if file != "" {
+		err := saveStateUpdate(file, su)
+		if err != nil {
+			log.Println("error saving state to "+file+":", err)
+		}
+	}
+
+	u.distribute(su, clients)
+}
+
+// distribute sends state update to specified clients
+func (u *Updater) distribute(su StateUpdate, clients map[string]chan StateUpdate) {
+	log.Printf("distributing generation %d among %d clients\n", su.Generation, len(clients))
 
 	wg := sync.WaitGroup{}
 	for n, c := range clients {
 		wg.Add(1)
 
-		go func(n string, c chan State) {
+		go func(n string, c chan StateUpdate) {
 			select {
-			case c <- s:
+			case c <- su:
 				break
 			case <-time.After(time.Second * 10):
 				log.Println("client " + n + " failed to respond in 10s, closing channel")
@@ -89,14 +287,32 @@ func (u *Updater) ListenForClients(listen string) error {
 		return err
 	}
 
+	if u.tls != nil {
+		l = tls.NewListener(l, u.tls)
+	}
+
+	u.mutex.Lock()
+	u.listener = l
+	u.mutex.Unlock()
+
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			select {
+			case <-u.done:
+				return nil
+			default:
+			}
+
 			log.Println("error accepting connection", err)
 			continue
 		}
 
+		u.sessions.Add(1)
+
 		go func() {
+			defer u.sessions.Done()
+
 			err := u.handleConnection(newClient(c))
 			if err != nil {
 				log.Println("error handling connection:", err)
@@ -105,41 +321,171 @@ func (u *Updater) ListenForClients(listen string) error {
 	}
 }
 
+// subscribe registers channel for state updates with specified name,
+// current state is returned along with channel if it is known
+func (u *Updater) subscribe(name string) (StateUpdate, chan StateUpdate, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.serve == nil {
+		return StateUpdate{}, nil, false
+	}
+
+	ch := make(chan StateUpdate)
+	u.clients[name] = ch
+
+	return *u.serve, ch, true
+}
+
+// unsubscribe removes channel for state updates with specified name
+func (u *Updater) unsubscribe(name string) {
+	u.mutex.Lock()
+	delete(u.clients, name)
+	u.mutex.Unlock()
+}
+
 // handleConnection handles connection with a client
 func (u *Updater) handleConnection(c *client) error {
 	defer func() {
-		u.mutex.Lock()
-		delete(u.clients, c.name)
-		u.mutex.Unlock()
-
+		u.unsubscribe(c.name)
 		c.Close()
 	}()
 
+	err := c.hello(u.token)
+	if err != nil {
+		return err
+	}
+
 	u.mutex.Lock()
-	apps := u.state
+	full := u.max > 0 && u.active >= u.max
+	if !full {
+		u.active++
+	}
+	u.mutex.Unlock()
 
-	// no apps -> no updates, closing instantly
-	if apps == nil {
+	if full {
+		log.Printf("client %s exceeds limit of %d clients, redirecting\n", c.name, u.max)
+		return c.redirect(u.redirect, false, time.Second*10)
+	}
+
+	u.mutex.Lock()
+	u.peers[c.name] = c
+	u.mutex.Unlock()
+
+	defer func() {
+		u.mutex.Lock()
+		u.active--
+		delete(u.peers, c.name)
 		u.mutex.Unlock()
+	}()
+
+	apps, ch, ok := u.subscribe(c.name)
+
+	// no apps -> no updates, closing instantly
+	if !ok {
 		return nil
 	}
 
-	ch := make(chan State)
-	u.clients[c.name] = ch
+	dead := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
 
-	u.mutex.Unlock()
+	if u.beat > 0 && !c.legacy {
+		args := func() PingArgs {
+			return u.pingArgs(c)
+		}
+
+		go heartbeat(c, u.beat, u.timeout, args, dead, stop)
+	}
 
-	err := c.reload(apps)
+	err = u.reload(c, apps)
 	if err != nil {
 		return err
 	}
 
-	for update := range ch {
-		err := c.reload(update)
-		if err != nil {
+	for {
+		select {
+		case update, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			err := u.reload(c, update)
+			if err != nil {
+				return err
+			}
+		case err := <-dead:
 			return err
+		case <-u.done:
+			return c.redirect(u.redirect, true, time.Second*10)
 		}
 	}
+}
+
+// reload sends state update to client and records apply result
+func (u *Updater) reload(c *client, s StateUpdate) error {
+	r, err := c.reload(s)
+	if err != nil {
+		r = ApplyResult{Generation: s.Generation, Error: err.Error()}
+
+		u.mutex.Lock()
+		u.reports.record(c.name, r)
+		u.rolling.report(c.name, r)
+		u.mutex.Unlock()
+
+		return err
+	}
+
+	// unchanged states are not sent, so client keeps the time
+	// of the last state it applied, failed and ignored states
+	// leave it with state that updater cannot vouch for
+	applied := time.Time{}
+	if c.last != nil {
+		applied = c.last.Time
+	}
+
+	u.mutex.Lock()
+	if !r.Ignored {
+		c.result = r
+	}
+	c.applied = applied
+	c.generation = s.Generation
+	u.reports.record(c.name, r)
+	u.rolling.report(c.name, r)
+	u.mutex.Unlock()
 
 	return nil
 }
+
+// pingArgs returns arguments for heartbeats to specified client with
+// freshness of served state, state is identified by the time of state
+// that client applied, which is older than served state if apps that
+// client is interested in did not change since then or if client is
+// a canary that applied state that is not served yet
+func (u *Updater) pingArgs(c *client) PingArgs {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.serve == nil || u.serve.Stale || c.applied.IsZero() {
+		return PingArgs{}
+	}
+
+	// served state is not handled by client yet,
+	// confirmation of it does not apply to client
+	if c.generation != u.serve.Generation {
+		return PingArgs{Time: c.applied}
+	}
+
+	return PingArgs{
+		Time:      c.applied,
+		Confirmed: u.confirmed,
+	}
+}
+
+// Reports returns apply reports for the latest generations of state
+func (u *Updater) Reports() []GenerationReport {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.reports.list()
+}
@@ -0,0 +1,4 @@
+package marathoner
+
+// Version is marathoner version reported by listeners to updaters
+const Version = "1.11-dev"